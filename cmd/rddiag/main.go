@@ -0,0 +1,44 @@
+// rddiag 扫描redis中的大 key并输出报告
+//
+//	go run ./cmd/rddiag -addr 127.0.0.1:6379 -match "order:*" -top 20 -json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oho-panda/utils/v2/rd"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "redis地址")
+	password := flag.String("password", "", "redis密码")
+	db := flag.Int("db", 0, "数据库编号")
+	match := flag.String("match", "*", "扫描的 key模式")
+	batch := flag.Int64("batch", 500, "每次 SCAN的数量")
+	top := flag.Int("top", 10, "每种类型输出的 key数量")
+	sleep := flag.Duration("sleep", 0, "每批扫描后的休眠时间")
+	asJSON := flag.Bool("json", false, "以 json格式输出报告")
+	flag.Parse()
+
+	rd.InitRedisClient(*addr, *password, *db, 10*time.Second)
+	ctx := context.Background()
+	report, err := rd.ScanBigKeys(ctx, rd.BigKeyOptions{
+		Match: *match,
+		Batch: *batch,
+		Top:   *top,
+		Sleep: *sleep,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		fmt.Println(string(report.JSON()))
+		return
+	}
+	report.Log(ctx)
+}
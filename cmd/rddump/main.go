@@ -0,0 +1,80 @@
+// rddump 将redis中的 key导出为 json lines文件，或从文件导入
+//
+//	go run ./cmd/rddump -addr 127.0.0.1:6379 -match "order:*" -file order.jsonl
+//	go run ./cmd/rddump -addr 127.0.0.1:6379 -import -file order.jsonl -rename order:,test:order: -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oho-panda/utils/v2/rd"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "redis地址")
+	password := flag.String("password", "", "redis密码")
+	db := flag.Int("db", 0, "数据库编号")
+	file := flag.String("file", "", "导出或导入的文件，为空时使用标准输出或标准输入")
+	doImport := flag.Bool("import", false, "从文件导入，默认为导出")
+	match := flag.String("match", "*", "导出的 key模式")
+	batch := flag.Int64("batch", 500, "每次 SCAN的数量")
+	sleep := flag.Duration("sleep", 0, "每批导出后的休眠时间")
+	dryRun := flag.Bool("dry-run", false, "只统计导入数量，不写入")
+	rename := flag.String("rename", "", "导入时替换 key前缀，格式为 old,new")
+	replace := flag.Bool("replace", false, "导入时覆盖已存在的 key")
+	flag.Parse()
+
+	rd.InitRedisClient(*addr, *password, *db, 10*time.Second)
+	ctx := context.Background()
+
+	if !*doImport {
+		out := os.Stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				exit(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		n, err := rd.Export(ctx, out, rd.ExportOptions{Match: *match, Batch: *batch, Sleep: *sleep})
+		if err != nil {
+			exit(err)
+		}
+		fmt.Fprintf(os.Stderr, "exported %d keys\n", n)
+		return
+	}
+
+	in := os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			exit(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	opts := rd.ImportOptions{DryRun: *dryRun, Replace: *replace}
+	if *rename != "" {
+		from, to, ok := strings.Cut(*rename, ",")
+		if !ok {
+			exit(fmt.Errorf("invalid rename %q, want old,new", *rename))
+		}
+		opts.RenameFrom, opts.RenameTo = from, to
+	}
+	result, err := rd.Import(ctx, in, opts)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Fprintf(os.Stderr, "restored %d keys, skipped %d keys, dry run: %v\n", result.Restored, result.Skipped, *dryRun)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
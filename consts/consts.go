@@ -2,5 +2,9 @@ package consts
 
 const (
 	TraceIdKey = "trace_id"
+	SpanIdKey  = "span_id"
 	CtxKey     = "ctx"
+	SessionKey = "session"
+	PrimaryKey = "rd_primary"
+	TenantKey  = "tenant"
 )
@@ -61,6 +61,7 @@ func (l *GormLogger) Error(ctx context.Context, msg string, data ...interface{})
 func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
 	elapsed := time.Since(begin)
 	sql, rows := fc()
+	gormSpan(ctx, begin, sql, rows, err)
 
 	if err != nil && !errors.Is(err, glog.ErrRecordNotFound) {
 		// 错误级别日志
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/oho-panda/utils/v2/consts"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -83,9 +84,17 @@ func ctxLog(ctx context.Context, level zapcore.Level, msg string, v ...any) {
 	value := ctx.Value(consts.TraceIdKey)
 	trace, ok := value.(string)
 	var field []zap.Field
+	// 没有 traceId时使用 OpenTelemetry span的 traceId
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !ok && sc.HasTraceID() {
+		trace, ok = sc.TraceID().String(), true
+	}
 	if ok {
 		field = append(field, zap.String(consts.TraceIdKey, trace))
 	}
+	if sc.HasSpanID() {
+		field = append(field, zap.String(consts.SpanIdKey, sc.SpanID().String()))
+	}
 	sprintf := fmt.Sprintf(msg, v...)
 	switch level {
 	case zapcore.DebugLevel:
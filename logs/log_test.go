@@ -2,7 +2,15 @@ package logs
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/oho-panda/utils/v2/consts"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 var (
@@ -53,3 +61,52 @@ func TestProductLogs(t *testing.T) {
 	CtxWarn(ctx, "测试哦哦哦哦哦")
 	CtxError(ctx, "测试哦哦哦哦哦")
 }
+
+func TestGormTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	EnableGormTracing(GormTracingOptions{Provider: provider, DBSystem: "mysql"})
+	t.Cleanup(func() { gormTracer = nil })
+	core, observed := observer.New(zapcore.InfoLevel)
+	ParseLevel("info")
+	InitLogs("TestGormTracing", core)
+
+	spanCtx, parent := provider.Tracer("test").Start(context.Background(), "handler")
+	sql := "SELECT * FROM `users` WHERE `phone` = '138''0000' AND `t1`.`age` > 18.5 LIMIT 1"
+	GLogger().Trace(spanCtx, time.Now(), func() (string, int64) { return sql, 1 }, nil)
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "gorm.query" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("spans = %v, want gorm.query child of handler", spans)
+	}
+	if stmt := spanAttr(spans[0], "db.statement"); stmt != "SELECT * FROM `users` WHERE `phone` = ? AND `t1`.`age` > ? LIMIT ?" {
+		t.Fatalf("statement = %q, want parameter values redacted", stmt)
+	}
+	fields := observed.All()[0].ContextMap()
+	if fields[consts.TraceIdKey] != parent.SpanContext().TraceID().String() || fields[consts.SpanIdKey] != parent.SpanContext().SpanID().String() {
+		t.Fatalf("log fields = %v, want otel trace and span id", fields)
+	}
+}
+
+func TestGormTracingWithArgs(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	EnableGormTracing(GormTracingOptions{Provider: provider, DBSystem: "sqlite", WithArgs: true})
+	t.Cleanup(func() { gormTracer = nil; gormWithArgs = false })
+
+	GLogger().Trace(context.Background(), time.Now(), func() (string, int64) { return `SELECT "138" ` + strings.Repeat("x", 2*statementLimit), 1 }, nil)
+	stmt := spanAttr(recorder.Ended()[0], "db.statement")
+	if len(stmt) != statementLimit || !strings.HasPrefix(stmt, `SELECT "138" `) {
+		t.Fatalf("statement = %q, want the full sql truncated to %d", stmt, statementLimit)
+	}
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	glog "gorm.io/gorm/logger"
+)
+
+// statementLimit db.statement属性的最大长度
+const statementLimit = 1024
+
+var (
+	gormTracer   trace.Tracer
+	gormSystem   string
+	gormWithArgs bool
+	gormLiterals *regexp.Regexp
+)
+
+// GormTracingOptions sql追踪配置
+type GormTracingOptions struct {
+	// 为 nil时使用 otel全局 provider
+	Provider trace.TracerProvider
+	// mysql、postgresql、sqlite等
+	DBSystem string
+	// 为 true时 db.statement记录代入参数后的完整 sql，否则参数值替换为 ?
+	// 参数中可能包含手机号、密码等敏感数据，只应在排查问题时开启
+	WithArgs bool
+}
+
+// EnableGormTracing 开启 sql追踪，GormLogger.Trace会为每条 sql生成一个 span
+func EnableGormTracing(opts GormTracingOptions) {
+	provider := opts.Provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	gormTracer = provider.Tracer("github.com/oho-panda/utils/v2/logs")
+	gormSystem = opts.DBSystem
+	gormWithArgs = opts.WithArgs
+	// gorm按方言的转义符代入字符串参数，sqlite为双引号，其余为单引号
+	quote := `'`
+	if opts.DBSystem == "sqlite" {
+		quote = `"`
+	}
+	gormLiterals = regexp.MustCompile(quote + `(?:[^` + quote + `]|` + quote + quote + `)*` + quote + `|\b\d+(?:\.\d+)?\b`)
+}
+
+// gormSpan 按 sql的开始和结束时间补记 span，挂在 ctx中的 span下
+func gormSpan(ctx context.Context, begin time.Time, sql string, rows int64, err error) {
+	if gormTracer == nil {
+		return
+	}
+	_, span := gormTracer.Start(ctx, "gorm.query",
+		trace.WithTimestamp(begin),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", gormSystem),
+			attribute.String("db.statement", gormStatement(sql)),
+			attribute.Int64("db.rows_affected", rows),
+		),
+	)
+	if err != nil && !errors.Is(err, glog.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// gormStatement 把 sql中的字符串和数字参数替换为 ?，开启 WithArgs时保留原文，超过 statementLimit时截断
+func gormStatement(sql string) string {
+	if !gormWithArgs {
+		sql = gormLiterals.ReplaceAllString(sql, "?")
+	}
+	if len(sql) > statementLimit {
+		sql = sql[:statementLimit]
+	}
+	return sql
+}
@@ -0,0 +1,298 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ bitmap 操作 ------------------------------------*/
+
+// SetBit 设置 key在 offset处的位值，并返回该位原来的值
+func SetBit(ctx context.Context, key string, offset int64, value int) int64 {
+	val, err := client.SetBit(ctx, key, offset, value).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// GetBit 获取 key在 offset处的位值
+func GetBit(ctx context.Context, key string, offset int64) int64 {
+	val, err := client.GetBit(ctx, key, offset).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitCount 统计 key中值为1的位数
+func BitCount(ctx context.Context, key string) int64 {
+	val, err := client.BitCount(ctx, key, nil).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitCountRange 统计 key在字节区间 [start, end] 内值为1的位数
+func BitCountRange(ctx context.Context, key string, start, end int64) int64 {
+	val, err := client.BitCount(ctx, key, &redis.BitCount{Start: start, End: end}).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitPos 返回 key中第一个值为 bit的位置，pos可选传入字节区间的 start和 end
+func BitPos(ctx context.Context, key string, bit int64, pos ...int64) int64 {
+	val, err := client.BitPos(ctx, key, bit, pos...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return -1
+	}
+	return val
+}
+
+// BitOpAnd 对多个 key做与运算，结果保存到 destKey，并返回结果的字节长度
+func BitOpAnd(ctx context.Context, destKey string, keys ...string) int64 {
+	val, err := client.BitOpAnd(ctx, destKey, keys...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitOpOr 对多个 key做或运算，结果保存到 destKey，并返回结果的字节长度
+func BitOpOr(ctx context.Context, destKey string, keys ...string) int64 {
+	val, err := client.BitOpOr(ctx, destKey, keys...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitOpXor 对多个 key做异或运算，结果保存到 destKey，并返回结果的字节长度
+func BitOpXor(ctx context.Context, destKey string, keys ...string) int64 {
+	val, err := client.BitOpXor(ctx, destKey, keys...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitOpNot 对 key做取反运算，结果保存到 destKey，并返回结果的字节长度
+func BitOpNot(ctx context.Context, destKey, key string) int64 {
+	val, err := client.BitOpNot(ctx, destKey, key).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// BitField 执行 BITFIELD 子命令，如 BitField(ctx, key, "incrby", "u8", 0, 1, "get", "u4", 8)
+func BitField(ctx context.Context, key string, values ...interface{}) []int64 {
+	val, err := client.BitField(ctx, key, values...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+/*------------------------------------ 日活 操作 ------------------------------------*/
+
+// ActiveKey 返回日活 bitmap的 key，格式为 prefix:20060102，位偏移量为用户ID
+func ActiveKey(prefix string, date time.Time) string {
+	return fmt.Sprintf("%s:%s", prefix, date.Format("20060102"))
+}
+
+// MarkActive 标记用户在 date当天活跃
+func MarkActive(ctx context.Context, prefix string, userID int64, date time.Time) bool {
+	err := client.SetBit(ctx, ActiveKey(prefix, date), userID, 1).Err()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// IsActive 判断用户在 date当天是否活跃
+func IsActive(ctx context.Context, prefix string, userID int64, date time.Time) bool {
+	return GetBit(ctx, ActiveKey(prefix, date), userID) == 1
+}
+
+// CountActive 统计 date当天的活跃用户数
+func CountActive(ctx context.Context, prefix string, date time.Time) int64 {
+	return BitCount(ctx, ActiveKey(prefix, date))
+}
+
+// CountActiveInRange 统计 [from, to] 日期区间内至少活跃过一天的用户数
+func CountActiveInRange(ctx context.Context, prefix string, from, to time.Time) int64 {
+	keys := activeKeys(prefix, from, to)
+	if len(keys) == 0 {
+		return 0
+	}
+	// 临时 key带随机后缀，避免并发统计同一区间时互相覆盖或删除
+	dest := fmt.Sprintf("%s:range:%s", prefix, newToken())
+	return bitOrCount(ctx, dest, keys)
+}
+
+// ActiveCohort 统计在所有 dates中均活跃的用户数，交集结果保存到 destKey并在 ex后过期
+func ActiveCohort(ctx context.Context, destKey string, ex time.Duration, prefix string, dates ...time.Time) int64 {
+	if len(dates) == 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(dates))
+	for _, date := range dates {
+		keys = append(keys, ActiveKey(prefix, date))
+	}
+	pipe := client.TxPipeline()
+	pipe.BitOpAnd(ctx, destKey, keys...)
+	pipe.Expire(ctx, destKey, ex)
+	count := pipe.BitCount(ctx, destKey, nil)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return 0
+	}
+	return count.Val()
+}
+
+// bitOrCount 将 keys做或运算到临时 key中，统计后删除临时 key
+func bitOrCount(ctx context.Context, dest string, keys []string) int64 {
+	pipe := client.TxPipeline()
+	pipe.BitOpOr(ctx, dest, keys...)
+	count := pipe.BitCount(ctx, dest, nil)
+	pipe.Del(ctx, dest)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return 0
+	}
+	return count.Val()
+}
+
+// activeKeys 返回 [from, to] 区间内每天的日活 key
+func activeKeys(prefix string, from, to time.Time) []string {
+	var keys []string
+	for d := truncateDay(from); !d.After(truncateDay(to)); d = d.AddDate(0, 0, 1) {
+		keys = append(keys, ActiveKey(prefix, d))
+	}
+	return keys
+}
+
+/*------------------------------------ 签到 操作 ------------------------------------*/
+
+// SignKey 返回用户签到 bitmap的 key，格式为 prefix:userID:2006，每年一个 key，位偏移量为当年的第几天
+func SignKey(prefix string, userID int64, year int) string {
+	return fmt.Sprintf("%s:%d:%d", prefix, userID, year)
+}
+
+// SignIn 用户在 date当天签到，返回 false表示当天已经签到过
+func SignIn(ctx context.Context, prefix string, userID int64, date time.Time) bool {
+	old, err := client.SetBit(ctx, SignKey(prefix, userID, date.Year()), int64(date.YearDay()-1), 1).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return old == 0
+}
+
+// IsSignedIn 判断用户在 date当天是否签到
+func IsSignedIn(ctx context.Context, prefix string, userID int64, date time.Time) bool {
+	return GetBit(ctx, SignKey(prefix, userID, date.Year()), int64(date.YearDay()-1)) == 1
+}
+
+// SignInStreak 返回截止到 date（含）用户连续签到的天数，支持跨年
+func SignInStreak(ctx context.Context, prefix string, userID int64, date time.Time) int64 {
+	var streak int64
+	day := date.YearDay() - 1
+	for year := date.Year(); ; year-- {
+		data := signBits(ctx, SignKey(prefix, userID, year))
+		for ; day >= 0; day-- {
+			if !bitAt(data, day) {
+				return streak
+			}
+			streak++
+		}
+		// 当年第一天也签到了，继续统计上一年
+		day = time.Date(year-1, 12, 31, 0, 0, 0, 0, date.Location()).YearDay() - 1
+	}
+}
+
+// SignInCount 统计用户在 [from, to] 日期区间内的签到天数
+func SignInCount(ctx context.Context, prefix string, userID int64, from, to time.Time) int64 {
+	var count int64
+	for year := from.Year(); year <= to.Year(); year++ {
+		data := signBits(ctx, SignKey(prefix, userID, year))
+		if len(data) == 0 {
+			continue
+		}
+		start, end := 0, len(data)*8-1
+		if year == from.Year() {
+			start = from.YearDay() - 1
+		}
+		if year == to.Year() && to.YearDay()-1 < end {
+			end = to.YearDay() - 1
+		}
+		count += countBits(data, start, end)
+	}
+	return count
+}
+
+// SignInCalendar 返回用户在 year年 month月每天的签到情况，下标0表示1号
+func SignInCalendar(ctx context.Context, prefix string, userID int64, year int, month time.Month) []bool {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	days := first.AddDate(0, 1, -1).Day()
+	// 一个月最多31天，使用 BITFIELD 一次取出整月的位
+	val := BitField(ctx, SignKey(prefix, userID, year), "get", fmt.Sprintf("u%d", days), first.YearDay()-1)
+	calendar := make([]bool, days)
+	if len(val) == 0 {
+		return calendar
+	}
+	for i := 0; i < days; i++ {
+		calendar[i] = val[0]>>(days-1-i)&1 == 1
+	}
+	return calendar
+}
+
+// signBits 获取签到 bitmap的原始字节，key不存在时返回 nil
+func signBits(ctx context.Context, key string) []byte {
+	data, err := client.Get(ctx, key).Bytes()
+	if err != nil && err != redis.Nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return data
+}
+
+// bitAt 判断 data第 offset位是否为1，与 redis的位顺序一致（高位在前）
+func bitAt(data []byte, offset int) bool {
+	if offset/8 >= len(data) {
+		return false
+	}
+	return data[offset/8]>>(7-offset%8)&1 == 1
+}
+
+// countBits 统计 data在位区间 [start, end] 内值为1的位数
+func countBits(data []byte, start, end int) int64 {
+	var count int64
+	for offset := start; offset <= end && offset/8 < len(data); {
+		if offset%8 == 0 && offset+7 <= end {
+			count += int64(bits.OnesCount8(data[offset/8]))
+			offset += 8
+			continue
+		}
+		if bitAt(data, offset) {
+			count++
+		}
+		offset++
+	}
+	return count
+}
+
+// truncateDay 截断到 t所在时区当天的零点
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
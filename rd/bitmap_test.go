@@ -0,0 +1,62 @@
+package rd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveUsers(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	day := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	next := day.AddDate(0, 0, 1)
+	MarkActive(ctx, "dau", 1, day)
+	MarkActive(ctx, "dau", 2, day)
+	MarkActive(ctx, "dau", 2, next)
+	MarkActive(ctx, "dau", 3, next)
+
+	if ActiveKey("dau", day) != "dau:20261001" || !IsActive(ctx, "dau", 1, day) || IsActive(ctx, "dau", 1, next) {
+		t.Fatal("active bits not set as expected")
+	}
+	if n := CountActive(ctx, "dau", day); n != 2 {
+		t.Fatalf("dau = %d, want 2", n)
+	}
+	if n := CountActiveInRange(ctx, "dau", day, next.Add(time.Hour)); n != 3 {
+		t.Fatalf("range = %d, want 3", n)
+	}
+	for _, key := range server.Keys() {
+		if strings.Contains(key, ":range:") {
+			t.Fatalf("temporary key %s should be deleted", key)
+		}
+	}
+	if n := ActiveCohort(ctx, "retained", time.Minute, "dau", day, next); n != 1 || server.TTL("retained") != time.Minute {
+		t.Fatalf("cohort = %d ttl %s, want 1 with a ttl", n, server.TTL("retained"))
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	// 跨年连续签到：2025-12-30 至 2026-01-02
+	start := time.Date(2025, 12, 30, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 4; i++ {
+		if !SignIn(ctx, "sign", 7, start.AddDate(0, 0, i)) {
+			t.Fatalf("day %d sign in should succeed", i)
+		}
+	}
+	if SignIn(ctx, "sign", 7, start) {
+		t.Fatal("signing in twice on the same day should return false")
+	}
+	jan2 := start.AddDate(0, 0, 3)
+	if n := SignInStreak(ctx, "sign", 7, jan2); n != 4 {
+		t.Fatalf("streak = %d, want 4 across the new year", n)
+	}
+	if n := SignInStreak(ctx, "sign", 7, jan2.AddDate(0, 0, 1)); n != 0 {
+		t.Fatalf("streak = %d, want 0 when today is missed", n)
+	}
+	if n := SignInCount(ctx, "sign", 7, start.AddDate(0, 0, 1), jan2); n != 3 {
+		t.Fatalf("count = %d, want 3", n)
+	}
+}
@@ -0,0 +1,356 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 熔断 操作 ------------------------------------*/
+
+// ErrCircuitOpen 熔断器打开时快速失败返回的错误
+var ErrCircuitOpen = errors.New("redis: circuit breaker is open")
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	// BreakerClosed 关闭，正常放行
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 打开，快速失败
+	BreakerOpen
+	// BreakerHalfOpen 半开，放行少量探测请求
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// readCommands 只读命令，熔断时可使用降级结果
+var readCommands = map[string]bool{
+	"get": true, "mget": true, "getrange": true, "strlen": true, "exists": true, "ttl": true, "pttl": true, "type": true,
+	"getbit": true, "bitcount": true, "bitpos": true, "bitfield_ro": true,
+	"hget": true, "hmget": true, "hgetall": true, "hkeys": true, "hvals": true, "hlen": true, "hexists": true,
+	"lindex": true, "llen": true, "lrange": true,
+	"scard": true, "sismember": true, "smismember": true, "smembers": true, "srandmember": true,
+	"zcard": true, "zcount": true, "zrange": true, "zrangebyscore": true, "zrevrange": true, "zrevrangebyscore": true,
+	"zrank": true, "zrevrank": true, "zscore": true, "zmscore": true,
+	"geopos": true, "geodist": true, "geohash": true, "geosearch": true,
+	"pfcount": true, "xrange": true, "xrevrange": true, "xlen": true,
+}
+
+// isReadCommand 判断是否为只读命令
+func isReadCommand(cmd redis.Cmder) bool {
+	return readCommands[strings.ToLower(cmd.Name())]
+}
+
+// Fallback 熔断降级，为熔断期间的读命令提供结果
+type Fallback interface {
+	// Store 保存成功的读命令结果
+	Store(cmd redis.Cmder)
+	// Load 为读命令填充降级结果，返回 false表示没有可用的结果
+	Load(cmd redis.Cmder) bool
+}
+
+// BreakerOptions 熔断器配置
+type BreakerOptions struct {
+	// 统计窗口，默认10秒
+	Window time.Duration
+	// 窗口内请求数达到该值才会判断是否熔断，默认20
+	MinRequests int
+	// 错误率阈值，默认0.5
+	ErrorRate float64
+	// 慢请求阈值及慢请求比例阈值，SlowThreshold为0时不统计慢请求
+	SlowThreshold time.Duration
+	SlowRate      float64
+	// 打开后经过该时长进入半开状态，默认5秒
+	OpenTimeout time.Duration
+	// 半开状态下连续成功该数量的探测请求后关闭，默认3
+	HalfOpenRequests int
+	// 熔断期间读命令的降级，为 nil时直接返回 ErrCircuitOpen
+	Fallback Fallback
+}
+
+// CircuitBreaker redis客户端熔断器
+type CircuitBreaker struct {
+	opts BreakerOptions
+
+	mu        sync.Mutex
+	state     BreakerState
+	openedAt  time.Time
+	winStart  time.Time
+	requests  int
+	failures  int
+	slows     int
+	probing   int
+	successes int
+}
+
+// EnableCircuitBreaker 为全局 redis客户端启用熔断器，需在 InitRedisClient之后调用
+func EnableCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	cb := NewCircuitBreaker(opts)
+	client.AddHook(cb)
+	return cb
+}
+
+// NewCircuitBreaker 创建熔断器，可通过 AddHook添加到任意 redis客户端
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.ErrorRate <= 0 {
+		opts.ErrorRate = 0.5
+	}
+	if opts.SlowRate <= 0 {
+		opts.SlowRate = 0.5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 3
+	}
+	return &CircuitBreaker{opts: opts, winStart: time.Now()}
+}
+
+// State 返回熔断器当前状态
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (cb *CircuitBreaker) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		fallback := cb.opts.Fallback != nil && isReadCommand(cmd)
+		if !cb.allow(ctx) {
+			if fallback && withTenantKeys(ctx, cmd, cb.opts.Fallback.Load) {
+				return nil
+			}
+			cmd.SetErr(ErrCircuitOpen)
+			return ErrCircuitOpen
+		}
+		start := time.Now()
+		err := next(ctx, cmd)
+		cb.record(ctx, err, time.Since(start))
+		if err == nil && fallback {
+			withTenantKeys(ctx, cmd, func(cmd redis.Cmder) bool {
+				cb.opts.Fallback.Store(cmd)
+				return true
+			})
+		}
+		return err
+	}
+}
+
+func (cb *CircuitBreaker) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !cb.allow(ctx) {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrCircuitOpen)
+			}
+			return ErrCircuitOpen
+		}
+		start := time.Now()
+		err := next(ctx, cmds)
+		cb.record(ctx, err, time.Since(start))
+		return err
+	}
+}
+
+// allow 判断是否放行请求
+func (cb *CircuitBreaker) allow(ctx context.Context) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.opts.OpenTimeout {
+			return false
+		}
+		cb.transition(ctx, BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if cb.probing >= cb.opts.HalfOpenRequests {
+			return false
+		}
+		cb.probing++
+	}
+	return true
+}
+
+// record 记录请求结果并按阈值切换状态
+func (cb *CircuitBreaker) record(ctx context.Context, err error, latency time.Duration) {
+	failed := isBreakerFailure(err)
+	slow := cb.opts.SlowThreshold > 0 && latency >= cb.opts.SlowThreshold
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case BreakerHalfOpen:
+		if cb.probing > 0 {
+			cb.probing--
+		}
+		if failed || slow {
+			cb.transition(ctx, BreakerOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.opts.HalfOpenRequests {
+			cb.transition(ctx, BreakerClosed)
+		}
+	case BreakerClosed:
+		if time.Since(cb.winStart) > cb.opts.Window {
+			cb.resetWindow()
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if slow {
+			cb.slows++
+		}
+		if cb.requests < cb.opts.MinRequests {
+			return
+		}
+		if float64(cb.failures)/float64(cb.requests) >= cb.opts.ErrorRate ||
+			(cb.opts.SlowThreshold > 0 && float64(cb.slows)/float64(cb.requests) >= cb.opts.SlowRate) {
+			cb.transition(ctx, BreakerOpen)
+		}
+	}
+}
+
+// transition 切换状态，调用方需持有锁
+func (cb *CircuitBreaker) transition(ctx context.Context, state BreakerState) {
+	if cb.state == state {
+		return
+	}
+	logs.CtxWarn(ctx, "redis circuit breaker %s -> %s, requests: %d, failures: %d, slows: %d",
+		cb.state, state, cb.requests, cb.failures, cb.slows)
+	cb.state = state
+	cb.probing = 0
+	cb.successes = 0
+	if state == BreakerOpen {
+		cb.openedAt = time.Now()
+	}
+	cb.resetWindow()
+}
+
+func (cb *CircuitBreaker) resetWindow() {
+	cb.winStart = time.Now()
+	cb.requests = 0
+	cb.failures = 0
+	cb.slows = 0
+}
+
+// isBreakerFailure 判断错误是否计入熔断统计，key不存在、命令错误等服务端正常响应及租户校验失败不计入
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrInvalidTenant) || errors.Is(err, ErrTenantCommand) || errors.Is(err, ErrTenantQuota) {
+		return false
+	}
+	var rerr redis.Error
+	if errors.As(err, &rerr) {
+		return false
+	}
+	return true
+}
+
+// LocalCacheFallback 本地缓存降级，缓存成功的 GET、HGET、HGETALL结果并在熔断期间返回
+type LocalCacheFallback struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	entries   map[string]localCacheEntry
+	lastSweep time.Time
+}
+
+type localCacheEntry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// NewLocalCacheFallback 创建本地缓存降级，ttl为缓存的最长有效期
+func NewLocalCacheFallback(ttl time.Duration) *LocalCacheFallback {
+	return &LocalCacheFallback{ttl: ttl, entries: map[string]localCacheEntry{}, lastSweep: time.Now()}
+}
+
+func (f *LocalCacheFallback) Store(cmd redis.Cmder) {
+	var value interface{}
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		value = c.Val()
+	case *redis.MapStringStringCmd:
+		value = c.Val()
+	default:
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if now.Sub(f.lastSweep) > f.ttl {
+		// 每个 ttl周期清理一次过期数据，避免无限增长
+		for k, e := range f.entries {
+			if now.After(e.expireAt) {
+				delete(f.entries, k)
+			}
+		}
+		f.lastSweep = now
+	}
+	f.entries[cmdKey(cmd)] = localCacheEntry{value: value, expireAt: now.Add(f.ttl)}
+}
+
+func (f *LocalCacheFallback) Load(cmd redis.Cmder) bool {
+	f.mu.RLock()
+	entry, ok := f.entries[cmdKey(cmd)]
+	f.mu.RUnlock()
+	if !ok || time.Now().After(entry.expireAt) {
+		return false
+	}
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		val, ok := entry.value.(string)
+		c.SetVal(val)
+		return ok
+	case *redis.MapStringStringCmd:
+		val, ok := entry.value.(map[string]string)
+		c.SetVal(val)
+		return ok
+	}
+	return false
+}
+
+// cmdKey 根据命令参数生成缓存 key，参数加引号后以空格分隔，避免相邻参数拼接后冲突
+func cmdKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = strconv.Quote(fmt.Sprint(arg))
+	}
+	return strings.Join(parts, " ")
+}
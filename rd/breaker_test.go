@@ -0,0 +1,27 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerFallbackKey(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	cb := EnableCircuitBreaker(BreakerOptions{OpenTimeout: time.Minute, Fallback: NewLocalCacheFallback(time.Minute)})
+	HSet(ctx, "a", "bc", "a/bc")
+	HSet(ctx, "ab", "c", "ab/c")
+	if v := HGet(ctx, "a", "bc"); v != "a/bc" {
+		t.Fatalf("hget = %q", v)
+	}
+
+	cb.transition(ctx, BreakerOpen)
+	if v, err := client.HGet(ctx, "a", "bc").Result(); err != nil || v != "a/bc" {
+		t.Fatalf("fallback hget a bc = %q %v", v, err)
+	}
+	if v, err := client.HGet(ctx, "ab", "c").Result(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("fallback hget ab c = %q %v, want ErrCircuitOpen", v, err)
+	}
+}
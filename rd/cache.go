@@ -0,0 +1,396 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 缓存标签 操作 ------------------------------------*/
+
+// 标签索引 key前缀，tag集合保存标签下的缓存 key，keytags集合保存缓存 key所属的标签
+const (
+	tagPrefix     = "cache:tag:"
+	keyTagsPrefix = "cache:keytags:"
+)
+
+// cacheTagScript 设置缓存并登记标签，或仅为已存在的 key登记标签
+// KEYS[1] 缓存 key，KEYS[2] 反向索引 key，KEYS[3..] 标签集合 key
+// ARGV[1] set或 tag，ARGV[2] 值，ARGV[3] 过期毫秒数（0为不过期），ARGV[4] 登记到标签集合的缓存 key，ARGV[5..] 标签名
+// 标签集合中登记调用方传入的 key而非 KEYS[1]，租户隔离下不带租户前缀，失效时可直接按原 key删除
+// 标签集合的过期时间取其下缓存的最大过期时间，保证索引不会早于缓存过期
+var cacheTagScript = redis.NewScript(`
+local ex = tonumber(ARGV[3])
+if ARGV[1] == 'set' then
+	if ex > 0 then
+		redis.call('SET', KEYS[1], ARGV[2], 'PX', ex)
+	else
+		redis.call('SET', KEYS[1], ARGV[2])
+	end
+else
+	ex = redis.call('PTTL', KEYS[1])
+	if ex == -2 then
+		return 0
+	end
+	if ex == -1 then
+		ex = 0
+	end
+end
+local function extend(k, existed)
+	if ex == 0 then
+		redis.call('PERSIST', k)
+		return
+	end
+	local ttl = redis.call('PTTL', k)
+	if existed == 0 or (ttl >= 0 and ttl < ex) then
+		redis.call('PEXPIRE', k, ex)
+	end
+end
+for i = 3, #KEYS do
+	local existed = redis.call('EXISTS', KEYS[i])
+	redis.call('SADD', KEYS[i], ARGV[4])
+	extend(KEYS[i], existed)
+end
+local existed = redis.call('EXISTS', KEYS[2])
+redis.call('SADD', KEYS[2], unpack(ARGV, 5))
+extend(KEYS[2], existed)
+return 1
+`)
+
+// invalidateRetries 标签集合在失效过程中被修改时的最大重试次数
+const invalidateRetries = 3
+
+// SetWithTags 设置缓存并登记到一个或多个标签下，ex为0时不过期
+func SetWithTags(ctx context.Context, key, value string, ex time.Duration, tags ...string) bool {
+	return cacheTag(ctx, "set", key, value, ex, tags)
+}
+
+// TagKeys 将已存在的 key登记到一个或多个标签下，适用于 hash、list等非字符串缓存，需在设置过期时间之后调用
+func TagKeys(ctx context.Context, key string, tags ...string) bool {
+	return cacheTag(ctx, "tag", key, "", 0, tags)
+}
+
+// InvalidateTag 原子地删除标签下的所有缓存及标签索引，并返回删除的缓存数
+// 如 SetWithTags(ctx, "order:123:detail", v, ex, "order:123") 后，订单变更时 InvalidateTag(ctx, "order:123")
+// 先读取标签下的 key，再通过 WATCH标签集合的事务删除，期间标签被修改时重试
+func InvalidateTag(ctx context.Context, tags ...string) int64 {
+	if len(tags) == 0 {
+		return 0
+	}
+	tagKeys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tagKeys = append(tagKeys, tagPrefix+tag)
+	}
+	var deleted int64
+	var err error = redis.TxFailedErr
+	for i := 0; i < invalidateRetries && errors.Is(err, redis.TxFailedErr); i++ {
+		err = client.Watch(ctx, func(tx *redis.Tx) error {
+			var e error
+			deleted, e = invalidateTags(ctx, tx, tagKeys)
+			return e
+		}, tagKeys...)
+	}
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return 0
+	}
+	return deleted
+}
+
+// invalidateTags 读取标签下的缓存 key及其所属的其他标签，在事务中删除缓存、反向索引和标签集合
+func invalidateTags(ctx context.Context, tx *redis.Tx, tagKeys []string) (int64, error) {
+	pipe := tx.Pipeline()
+	memberCmds := make([]*redis.StringSliceCmd, len(tagKeys))
+	for i, tagKey := range tagKeys {
+		memberCmds[i] = pipe.SMembers(ctx, tagKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var keys []string
+	for _, cmd := range memberCmds {
+		for _, key := range cmd.Val() {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	revCmds := make([]*redis.StringSliceCmd, len(keys))
+	if len(keys) > 0 {
+		pipe = tx.Pipeline()
+		for i, key := range keys {
+			revCmds[i] = pipe.SMembers(ctx, keyTagsPrefix+key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	dels := make([]*redis.IntCmd, len(keys))
+	_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			for _, tag := range revCmds[i].Val() {
+				if !slices.Contains(tagKeys, tagPrefix+tag) {
+					pipe.SRem(ctx, tagPrefix+tag, key)
+				}
+			}
+			pipe.Del(ctx, keyTagsPrefix+key)
+			dels[i] = pipe.Del(ctx, key)
+		}
+		pipe.Del(ctx, tagKeys...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, del := range dels {
+		deleted += del.Val()
+	}
+	return deleted, nil
+}
+
+// TagMembers 返回标签下登记的缓存 key，其中可能包含已过期的 key
+func TagMembers(ctx context.Context, tag string) []string {
+	return SMembers(ctx, tagPrefix+tag)
+}
+
+func cacheTag(ctx context.Context, mode, key, value string, ex time.Duration, tags []string) bool {
+	if len(tags) == 0 {
+		if mode == "set" {
+			return SetEX(ctx, key, value, ex)
+		}
+		return true
+	}
+	keys := make([]string, 0, len(tags)+2)
+	keys = append(keys, key, keyTagsPrefix+key)
+	args := make([]interface{}, 0, len(tags)+4)
+	args = append(args, mode, value, ex.Milliseconds(), key)
+	for _, tag := range tags {
+		keys = append(keys, tagPrefix+tag)
+		args = append(args, tag)
+	}
+	val, err := cacheTagScript.Run(ctx, client, keys, args...).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return val == 1
+}
+
+/*------------------------------------ 缓存预热与提前刷新 操作 ------------------------------------*/
+
+// LoadFunc 从数据源加载缓存值
+type LoadFunc func(ctx context.Context) (string, error)
+
+// ReadThroughOptions 读穿缓存配置
+type ReadThroughOptions struct {
+	// 缓存过期时间
+	TTL time.Duration
+	// 剩余过期时间低于 TTL的该比例时，访问会触发后台刷新，默认0.2，小于0时不提前刷新
+	RefreshRatio float64
+	// 后台刷新的最大并发数，超过时跳过本次刷新，默认16
+	RefreshConcurrency int
+}
+
+// ReadThrough 读穿缓存，未命中时加载并写入缓存，同一进程内同一 key的并发加载只执行一次
+// 临近过期的缓存被访问时在后台提前刷新，多实例间通过 redis锁保证同一时间只有一个实例刷新
+type ReadThrough struct {
+	opts    ReadThroughOptions
+	sem     chan struct{}
+	mu      sync.Mutex
+	loading map[string]*loadCall
+}
+
+type loadCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+// NewReadThrough 创建读穿缓存
+func NewReadThrough(opts ReadThroughOptions) *ReadThrough {
+	if opts.RefreshRatio == 0 {
+		opts.RefreshRatio = 0.2
+	}
+	if opts.RefreshConcurrency <= 0 {
+		opts.RefreshConcurrency = 16
+	}
+	return &ReadThrough{
+		opts:    opts,
+		sem:     make(chan struct{}, opts.RefreshConcurrency),
+		loading: map[string]*loadCall{},
+	}
+}
+
+// Get 读取缓存，未命中时调用 load加载并写入缓存，redis不可用时直接返回 load的结果
+func (rt *ReadThrough) Get(ctx context.Context, key string, load LoadFunc) (string, error) {
+	pipe := client.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+	switch {
+	case err == nil:
+		if ttl := pttl.Val(); rt.opts.RefreshRatio > 0 && ttl > 0 && float64(ttl) < float64(rt.opts.TTL)*rt.opts.RefreshRatio {
+			rt.refreshAsync(ctx, key, load)
+		}
+		return get.Val(), nil
+	case errors.Is(err, redis.Nil):
+		return rt.load(ctx, key, load, true)
+	default:
+		logs.CtxWarn(ctx, err.Error())
+		return load(ctx)
+	}
+}
+
+// Load 调用 load加载并写入缓存，同一 key的并发调用共享一次加载结果，可用于预热
+func (rt *ReadThrough) Load(ctx context.Context, key string, load LoadFunc) (string, error) {
+	return rt.load(ctx, key, load, false)
+}
+
+// load 加载并写入缓存，recheck为 true时先检查缓存是否已被刚结束的加载写入
+// load panic时转为错误返回给本次及等待中的调用方，不会阻塞之后对该 key的加载
+func (rt *ReadThrough) load(ctx context.Context, key string, load LoadFunc, recheck bool) (val string, err error) {
+	rt.mu.Lock()
+	if c, ok := rt.loading[key]; ok {
+		rt.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &loadCall{}
+	c.wg.Add(1)
+	rt.loading[key] = c
+	rt.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = "", fmt.Errorf("cache load %s panic: %v", key, r)
+			logs.CtxError(ctx, c.err.Error())
+		}
+		c.wg.Done()
+		rt.mu.Lock()
+		delete(rt.loading, key)
+		rt.mu.Unlock()
+		val, err = c.val, c.err
+	}()
+
+	var cached bool
+	if recheck {
+		c.val, c.err = client.Get(ctx, key).Result()
+		cached = c.err == nil
+	}
+	if !cached {
+		if c.val, c.err = load(ctx); c.err == nil {
+			SetEX(ctx, key, c.val, rt.opts.TTL)
+		}
+	}
+	return c.val, c.err
+}
+
+// refreshUnlockScript 刷新锁仍是自己持有时删除，避免刷新超过锁的过期时间后删除其他实例的锁
+var refreshUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshAsync 在后台刷新缓存，并发已满或其他实例正在刷新时跳过
+func (rt *ReadThrough) refreshAsync(ctx context.Context, key string, load LoadFunc) {
+	select {
+	case rt.sem <- struct{}{}:
+	default:
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-rt.sem }()
+		lock, token := key+":refreshing", newToken()
+		ok, err := client.SetNX(ctx, lock, token, time.Duration(float64(rt.opts.TTL)*rt.opts.RefreshRatio)).Result()
+		if err != nil || !ok {
+			return
+		}
+		defer func() {
+			if err := refreshUnlockScript.Run(ctx, client, []string{lock}, token).Err(); err != nil {
+				logs.CtxWarn(ctx, err.Error())
+			}
+		}()
+		if _, err = rt.Load(ctx, key, load); err != nil {
+			logs.CtxWarn(ctx, "cache refresh %s failed: %s", key, err.Error())
+		}
+	}()
+}
+
+// WarmupFunc 缓存预热函数
+type WarmupFunc func(ctx context.Context) error
+
+var (
+	warmupMu sync.Mutex
+	warmups  = map[string]WarmupFunc{}
+)
+
+// RegisterWarmup 注册缓存预热函数，同名的函数会被覆盖
+func RegisterWarmup(name string, fn WarmupFunc) {
+	warmupMu.Lock()
+	warmups[name] = fn
+	warmupMu.Unlock()
+}
+
+// Warmup 以最多 concurrency的并发执行所有预热函数，等待全部完成后返回所有失败的错误
+// 通常在服务启动、开始接收请求之前调用
+func Warmup(ctx context.Context, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	warmupMu.Lock()
+	fns := make(map[string]WarmupFunc, len(warmups))
+	for name, fn := range warmups {
+		fns[name] = fn
+	}
+	warmupMu.Unlock()
+
+	start := time.Now()
+	sem := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for name, fn := range fns {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// ctx结束后不再启动剩余的预热函数
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("cache warmup: %w", ctx.Err()))
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(name string, fn WarmupFunc) {
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("cache warmup %s panic: %v", name, r))
+					mu.Unlock()
+				}
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx); err != nil {
+				logs.CtxWarn(ctx, "cache warmup %s failed: %s", name, err.Error())
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("cache warmup %s: %w", name, err))
+				mu.Unlock()
+			}
+		}(name, fn)
+	}
+	wg.Wait()
+	logs.CtxInfo(ctx, "cache warmup finished, loaders: %d, failed: %d, elapsed: %s", len(fns), len(errs), time.Since(start))
+	return errors.Join(errs...)
+}
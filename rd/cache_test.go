@@ -0,0 +1,201 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	SetWithTags(ctx, "order:1:detail", "d", time.Minute, "order:1")
+	SetWithTags(ctx, "order:1:items", "i", 2*time.Minute, "order:1", "user:9")
+	SetWithTags(ctx, "user:9:profile", "p", 0, "user:9")
+	HSet(ctx, "order:1:stats", "n", "1")
+	Expire(ctx, "order:1:stats", time.Minute)
+	TagKeys(ctx, "order:1:stats", "order:1")
+
+	if ttl := server.TTL(tagPrefix + "order:1"); ttl != 2*time.Minute {
+		t.Fatalf("tag ttl = %s, want the longest entry ttl", ttl)
+	}
+	if deleted := InvalidateTag(ctx, "order:1"); deleted != 3 {
+		t.Fatalf("deleted = %d, want 3", deleted)
+	}
+	for _, key := range []string{"order:1:detail", "order:1:items", "order:1:stats", tagPrefix + "order:1", keyTagsPrefix + "order:1:items"} {
+		if server.Exists(key) {
+			t.Fatalf("%s should be deleted", key)
+		}
+	}
+	if members := TagMembers(ctx, "user:9"); len(members) != 1 || members[0] != "user:9:profile" {
+		t.Fatalf("user:9 members = %v, want only profile", members)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	rt := NewReadThrough(ReadThroughOptions{TTL: 10 * time.Second})
+	var loads atomic.Int32
+	load := func(ctx context.Context) (string, error) {
+		return fmt.Sprintf("v%d", loads.Add(1)), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := rt.Get(ctx, "rt:key", load); err != nil || v != "v1" {
+				t.Errorf("get = %q %v, want v1", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Fatalf("loads = %d, want concurrent misses to load once", loads.Load())
+	}
+
+	server.FastForward(9 * time.Second)
+	if v, _ := rt.Get(ctx, "rt:key", load); v != "v1" {
+		t.Fatalf("near expiry get = %q, want the cached value", v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for server.TTL("rt:key") <= time.Second && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, v := Get(ctx, "rt:key"); v != "v2" || server.TTL("rt:key") != 10*time.Second {
+		t.Fatalf("refreshed value = %q ttl %s, want v2 with a full ttl", v, server.TTL("rt:key"))
+	}
+
+	if _, err := rt.Get(ctx, "rt:fail", func(ctx context.Context) (string, error) { return "", errors.New("db down") }); err == nil || server.Exists("rt:fail") {
+		t.Fatalf("failed load err = %v, should not be cached", err)
+	}
+}
+
+func TestReadThroughPanic(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	rt := NewReadThrough(ReadThroughOptions{TTL: 10 * time.Second})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	panicking := func(ctx context.Context) (string, error) {
+		once.Do(func() { close(started) })
+		<-release
+		panic("boom")
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := rt.Get(ctx, "rt:panic", panicking)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err := rt.Get(ctx, "rt:panic", panicking)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil || !strings.Contains(err.Error(), "panic: boom") {
+			t.Fatalf("err = %v, want the panic as an error", err)
+		}
+	}
+
+	v, err := rt.Get(ctx, "rt:panic", func(ctx context.Context) (string, error) { return "ok", nil })
+	if err != nil || v != "ok" {
+		t.Fatalf("get after panic = %q %v, the key should not stay stuck", v, err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	t.Cleanup(func() { warmups = map[string]WarmupFunc{} })
+	var running, peak atomic.Int32
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprintf("warm:%d", i)
+		RegisterWarmup(key, func(ctx context.Context) error {
+			if n := running.Add(1); n > peak.Load() {
+				peak.Store(n)
+			}
+			defer running.Add(-1)
+			time.Sleep(10 * time.Millisecond)
+			SetEX(ctx, key, "ok", time.Minute)
+			return nil
+		})
+	}
+	RegisterWarmup("broken", func(ctx context.Context) error { return errors.New("boom") })
+
+	if err := Warmup(ctx, 2); err == nil {
+		t.Fatal("warmup should report the failed loader")
+	}
+	if peak.Load() > 2 {
+		t.Fatalf("peak concurrency = %d, want at most 2", peak.Load())
+	}
+	for i := 0; i < 6; i++ {
+		if ok, _ := Get(ctx, fmt.Sprintf("warm:%d", i)); !ok {
+			t.Fatalf("warm:%d not loaded", i)
+		}
+	}
+}
+
+func TestReadThroughRefreshLock(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	rt := NewReadThrough(ReadThroughOptions{TTL: 10 * time.Second})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	SetEX(ctx, "rt:key", "v1", time.Second)
+	rt.refreshAsync(ctx, "rt:key", func(ctx context.Context) (string, error) {
+		defer close(done)
+		<-release
+		return "v2", nil
+	})
+
+	// 刷新超过锁的过期时间，锁被其他实例获取
+	deadline := time.Now().Add(time.Second)
+	for !server.Exists("rt:key:refreshing") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	server.FastForward(10 * time.Second)
+	_ = server.Set("rt:key:refreshing", "other")
+	close(release)
+	<-done
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := server.Get("rt:key:refreshing"); v != "other" {
+		t.Fatalf("lock = %q, the refresh should not delete another instance's lock", v)
+	}
+}
+
+func TestWarmupCancel(t *testing.T) {
+	startRedis(t)
+	t.Cleanup(func() { warmups = map[string]WarmupFunc{} })
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	var started atomic.Int32
+	for i := 0; i < 3; i++ {
+		RegisterWarmup(fmt.Sprintf("slow:%d", i), func(ctx context.Context) error {
+			started.Add(1)
+			<-block
+			return nil
+		})
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- Warmup(ctx, 1) }()
+	for started.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	close(block)
+	if err := <-errs; !errors.Is(err, context.Canceled) || started.Load() != 1 {
+		t.Fatalf("warmup err = %v, started %d, want canceled before the rest start", err, started.Load())
+	}
+}
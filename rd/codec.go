@@ -0,0 +1,428 @@
+package rd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 编解码 操作 ------------------------------------*/
+
+// Codec 缓存值编解码
+type Codec interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// AADCodec 支持附加认证数据的编解码，解码时需传入与编码时相同的附加数据，否则解码失败
+type AADCodec interface {
+	Codec
+	EncodeAAD(data, aad []byte) ([]byte, error)
+	DecodeAAD(data, aad []byte) ([]byte, error)
+}
+
+// ErrCodecFormat 数据格式错误，无法解码
+var ErrCodecFormat = errors.New("codec: invalid data format")
+
+// Compression 压缩算法，编码后的第一个字节为算法标识，解码时根据标识选择算法，切换算法后旧数据仍可读取
+type Compression byte
+
+const (
+	// CompressNone 未压缩
+	CompressNone Compression = iota
+	CompressGzip
+	CompressZstd
+	CompressSnappy
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressCodec 压缩编解码
+type compressCodec struct {
+	algo      Compression
+	threshold int
+}
+
+// NewCompressCodec 创建压缩编解码，数据长度小于 threshold时不压缩
+func NewCompressCodec(algo Compression, threshold int) Codec {
+	return &compressCodec{algo: algo, threshold: threshold}
+}
+
+func (c *compressCodec) Encode(data []byte) ([]byte, error) {
+	if len(data) < c.threshold || c.algo == CompressNone {
+		return append([]byte{byte(CompressNone)}, data...), nil
+	}
+	switch c.algo {
+	case CompressGzip:
+		var buf bytes.Buffer
+		buf.WriteByte(byte(CompressGzip))
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressZstd:
+		return zstdEncoder.EncodeAll(data, []byte{byte(CompressZstd)}), nil
+	case CompressSnappy:
+		return append([]byte{byte(CompressSnappy)}, s2.EncodeSnappy(nil, data)...), nil
+	}
+	return nil, fmt.Errorf("codec: unknown compression %d", c.algo)
+}
+
+func (c *compressCodec) Decode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrCodecFormat
+	}
+	body := data[1:]
+	switch Compression(data[0]) {
+	case CompressNone:
+		return body, nil
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressZstd:
+		return zstdDecoder.DecodeAll(body, nil)
+	case CompressSnappy:
+		return s2.Decode(nil, body)
+	}
+	return nil, ErrCodecFormat
+}
+
+// aesCodec AES-GCM信封加密，每个值使用随机数据密钥加密，数据密钥再由主密钥加密
+// 格式：版本(1) | 主密钥ID长度(1) | 主密钥ID | 加密的数据密钥(12+32+16) | nonce(12) | 密文
+// 带附加数据加密时版本为2，数据密钥和密文均绑定附加数据，版本1的数据解码时忽略附加数据
+type aesCodec struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+const (
+	aesCodecVersion    = 1
+	aesCodecVersionAAD = 2
+	dataKeySize        = 32
+)
+
+// NewAESCodec 创建 AES-GCM信封加密编解码，keys为主密钥ID到16、24或32字节主密钥的映射，使用 current加密
+// 轮换密钥时加入新密钥并切换 current，旧密钥保留到旧数据过期即可
+func NewAESCodec(current string, keys map[string][]byte) (Codec, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("codec: key %q not found", current)
+	}
+	if len(current) > 255 {
+		return nil, errors.New("codec: key id too long")
+	}
+	c := &aesCodec{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("codec: key %q: %w", id, err)
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+func (c *aesCodec) Encode(data []byte) ([]byte, error) {
+	return c.EncodeAAD(data, nil)
+}
+
+func (c *aesCodec) Decode(data []byte) ([]byte, error) {
+	return c.DecodeAAD(data, nil)
+}
+
+func (c *aesCodec) EncodeAAD(data, aad []byte) ([]byte, error) {
+	version := byte(aesCodecVersion)
+	if aad != nil {
+		version = aesCodecVersionAAD
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(c.keys[c.current], dataKey, aad)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	body, err := gcmSeal(aead, data, aad)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 2+len(c.current)+len(wrapped)+len(body))
+	out = append(out, version, byte(len(c.current)))
+	out = append(out, c.current...)
+	out = append(out, wrapped...)
+	return append(out, body...), nil
+}
+
+func (c *aesCodec) DecodeAAD(data, aad []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, ErrCodecFormat
+	}
+	switch data[0] {
+	case aesCodecVersion:
+		aad = nil
+	case aesCodecVersionAAD:
+	default:
+		return nil, ErrCodecFormat
+	}
+	idLen := int(data[1])
+	wrappedLen := 12 + dataKeySize + 16
+	if len(data) < 2+idLen+wrappedLen {
+		return nil, ErrCodecFormat
+	}
+	id := string(data[2 : 2+idLen])
+	master, ok := c.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("codec: key %q not found", id)
+	}
+	rest := data[2+idLen:]
+	dataKey, err := gcmOpen(master, rest[:wrappedLen], aad)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(aead, rest[wrappedLen:], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmSeal 加密，返回 nonce | 密文，aad为附加认证数据
+func gcmSeal(aead cipher.AEAD, plain, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+// gcmOpen 解密 nonce | 密文，aad需与加密时一致
+func gcmOpen(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrCodecFormat
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// chainCodec 组合多个编解码，编码时依次执行，解码时逆序执行
+type chainCodec []Codec
+
+// ChainCodec 组合多个编解码，如 ChainCodec(压缩, 加密) 先压缩再加密
+func ChainCodec(codecs ...Codec) Codec {
+	return chainCodec(codecs)
+}
+
+func (c chainCodec) Encode(data []byte) ([]byte, error) {
+	return c.EncodeAAD(data, nil)
+}
+
+func (c chainCodec) Decode(data []byte) ([]byte, error) {
+	return c.DecodeAAD(data, nil)
+}
+
+// EncodeAAD 依次编码，附加数据传给其中支持附加数据的编解码
+func (c chainCodec) EncodeAAD(data, aad []byte) ([]byte, error) {
+	var err error
+	for _, codec := range c {
+		if data, err = encodeAAD(codec, data, aad); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// DecodeAAD 逆序解码，附加数据传给其中支持附加数据的编解码
+func (c chainCodec) DecodeAAD(data, aad []byte) ([]byte, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if data, err = decodeAAD(c[i], data, aad); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// encodeAAD 编解码支持附加数据时带附加数据编码，否则忽略附加数据
+func encodeAAD(codec Codec, data, aad []byte) ([]byte, error) {
+	if c, ok := codec.(AADCodec); ok && aad != nil {
+		return c.EncodeAAD(data, aad)
+	}
+	return codec.Encode(data)
+}
+
+// decodeAAD 编解码支持附加数据时带附加数据解码，否则忽略附加数据
+func decodeAAD(codec Codec, data, aad []byte) ([]byte, error) {
+	if c, ok := codec.(AADCodec); ok && aad != nil {
+		return c.DecodeAAD(data, aad)
+	}
+	return codec.Decode(data)
+}
+
+// CodecStore 使用编解码读写字符串和 hash，用法与同名的包函数一致
+type CodecStore struct {
+	codec Codec
+	aad   bool
+}
+
+// NewCodecStore 创建使用编解码的读写，传入多个编解码时按 ChainCodec组合
+func NewCodecStore(codecs ...Codec) *CodecStore {
+	if len(codecs) == 1 {
+		return &CodecStore{codec: codecs[0]}
+	}
+	return &CodecStore{codec: ChainCodec(codecs...)}
+}
+
+// WithAAD 返回以 redis key及 hash字段作为附加认证数据的 CodecStore，密文被复制到其他 key或字段后无法解密
+// 开启前写入的数据仍可读取，开启后写入的数据只能由开启了该选项的 CodecStore读取，RENAME等移动 key的操作会使数据无法解密
+func (s *CodecStore) WithAAD() *CodecStore {
+	return &CodecStore{codec: s.codec, aad: true}
+}
+
+// encode 编码 key或 hash字段的值
+func (s *CodecStore) encode(key, field string, value []byte) ([]byte, error) {
+	return encodeAAD(s.codec, value, s.aadFor(key, field))
+}
+
+// decode 解码 key或 hash字段的值
+func (s *CodecStore) decode(key, field string, data []byte) ([]byte, error) {
+	return decodeAAD(s.codec, data, s.aadFor(key, field))
+}
+
+// aadFor 返回附加认证数据：key长度(4) | key | hash字段，未开启时返回 nil
+func (s *CodecStore) aadFor(key, field string) []byte {
+	if !s.aad {
+		return nil
+	}
+	aad := make([]byte, 4, 4+len(key)+len(field))
+	binary.BigEndian.PutUint32(aad, uint32(len(key)))
+	aad = append(aad, key...)
+	return append(aad, field...)
+}
+
+// Set 编码后设置 key的值
+func (s *CodecStore) Set(ctx context.Context, key, value string) bool {
+	return s.SetEX(ctx, key, value, 0)
+}
+
+// SetEX 编码后设置 key的值并指定过期时间
+func (s *CodecStore) SetEX(ctx context.Context, key, value string, ex time.Duration) bool {
+	data, err := s.encode(key, "", []byte(value))
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	if err = client.Set(ctx, key, data, ex).Err(); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// Get 获取 key的值并解码
+func (s *CodecStore) Get(ctx context.Context, key string) (bool, string) {
+	data, err := client.Get(ctx, key).Bytes()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false, ""
+	}
+	value, err := s.decode(key, "", data)
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false, ""
+	}
+	return true, string(value)
+}
+
+// HSet 编码后设置 hash字段的值
+func (s *CodecStore) HSet(ctx context.Context, key, field, value string) bool {
+	return s.HMSet(ctx, key, map[string]string{field: value})
+}
+
+// HMSet 编码后批量设置 hash字段的值
+func (s *CodecStore) HMSet(ctx context.Context, key string, data map[string]string) bool {
+	values := make([]interface{}, 0, len(data)*2)
+	for field, value := range data {
+		encoded, err := s.encode(key, field, []byte(value))
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return false
+		}
+		values = append(values, field, encoded)
+	}
+	if err := client.HSet(ctx, key, values...).Err(); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// HGet 获取 hash字段的值并解码
+func (s *CodecStore) HGet(ctx context.Context, key, field string) string {
+	data, err := client.HGet(ctx, key, field).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logs.CtxWarn(ctx, err.Error())
+		}
+		return ""
+	}
+	value, err := s.decode(key, field, data)
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return ""
+	}
+	return string(value)
+}
+
+// HGetAll 获取 hash所有字段并解码，解码失败的字段会被忽略
+func (s *CodecStore) HGetAll(ctx context.Context, key string) map[string]string {
+	data, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil
+	}
+	result := make(map[string]string, len(data))
+	for field, raw := range data {
+		value, err := s.decode(key, field, []byte(raw))
+		if err != nil {
+			logs.CtxWarn(ctx, "decode %s.%s failed: %s", key, field, err.Error())
+			continue
+		}
+		result[field] = string(value)
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+package rd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompressCodec(t *testing.T) {
+	data := []byte(strings.Repeat("hello redis ", 100))
+	for _, algo := range []Compression{CompressNone, CompressGzip, CompressZstd, CompressSnappy} {
+		codec := NewCompressCodec(algo, 64)
+		encoded, err := codec.Encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algo != CompressNone && len(encoded) >= len(data) {
+			t.Errorf("algo %d: encoded %d bytes, want less than %d", algo, len(encoded), len(data))
+		}
+		// 使用其他算法配置的编解码也能解码
+		decoded, err := NewCompressCodec(CompressGzip, 0).Decode(encoded)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Fatalf("algo %d: decode = %v, %v", algo, len(decoded), err)
+		}
+	}
+	encoded, _ := NewCompressCodec(CompressZstd, 64).Encode([]byte("short"))
+	if Compression(encoded[0]) != CompressNone {
+		t.Fatalf("short value should not be compressed")
+	}
+}
+
+func TestAESCodecRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 16)
+	oldCodec, err := NewAESCodec("v1", map[string][]byte{"v1": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := oldCodec.Encode([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewAESCodec("v2", map[string][]byte{"v1": oldKey, "v2": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := rotated.Decode(encoded)
+	if err != nil || string(decoded) != "secret" {
+		t.Fatalf("decode with rotated keys = %q, %v", decoded, err)
+	}
+	encoded, _ = rotated.Encode([]byte("secret"))
+	if _, err = oldCodec.Decode(encoded); err == nil {
+		t.Fatal("decode with missing key should fail")
+	}
+	encoded[len(encoded)-1] ^= 1
+	if _, err = rotated.Decode(encoded); err == nil {
+		t.Fatal("decode tampered data should fail")
+	}
+}
+
+func TestCodecStore(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	aesCodec, _ := NewAESCodec("k", map[string][]byte{"k": bytes.Repeat([]byte{3}, 32)})
+	store := NewCodecStore(NewCompressCodec(CompressGzip, 16), aesCodec)
+	value := strings.Repeat("payload", 20)
+	if !store.Set(ctx, "codec", value) {
+		t.Fatal("set failed")
+	}
+	if _, raw := Get(ctx, "codec"); strings.Contains(raw, "payload") {
+		t.Fatal("stored value should be encrypted")
+	}
+	if ok, got := store.Get(ctx, "codec"); !ok || got != value {
+		t.Fatalf("get = %v %q", ok, got)
+	}
+	store.HMSet(ctx, "codec:h", map[string]string{"a": value, "b": "x"})
+	if got := store.HGetAll(ctx, "codec:h"); got["a"] != value || got["b"] != "x" {
+		t.Fatalf("hgetall = %v", got)
+	}
+	if got := store.HGet(ctx, "codec:h", "b"); got != "x" {
+		t.Fatalf("hget = %q", got)
+	}
+}
+
+func TestCodecStoreAAD(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	aesCodec, _ := NewAESCodec("k", map[string][]byte{"k": bytes.Repeat([]byte{4}, 32)})
+	plain := NewCodecStore(NewCompressCodec(CompressZstd, 16), aesCodec)
+	store := plain.WithAAD()
+
+	store.Set(ctx, "aad:a", "secret")
+	_, raw := Get(ctx, "aad:a")
+	Set(ctx, "aad:b", raw)
+	if ok, v := store.Get(ctx, "aad:a"); !ok || v != "secret" {
+		t.Fatalf("get = %v %q", ok, v)
+	}
+	if ok, _ := store.Get(ctx, "aad:b"); ok {
+		t.Fatal("value copied to another key should not decrypt")
+	}
+	if ok, _ := plain.Get(ctx, "aad:a"); ok {
+		t.Fatal("value bound to its key should not decrypt without aad")
+	}
+
+	store.HSet(ctx, "aad:h", "f1", "secret")
+	HSet(ctx, "aad:h", "f2", HGet(ctx, "aad:h", "f1"))
+	if v := store.HGet(ctx, "aad:h", "f1"); v != "secret" {
+		t.Fatalf("hget = %q", v)
+	}
+	if got := store.HGetAll(ctx, "aad:h"); got["f1"] != "secret" || len(got) != 1 {
+		t.Fatalf("hgetall = %v, value copied to another field should be dropped", got)
+	}
+
+	plain.Set(ctx, "aad:old", "legacy")
+	if ok, v := store.Get(ctx, "aad:old"); !ok || v != "legacy" {
+		t.Fatalf("value written before enabling aad = %v %q", ok, v)
+	}
+}
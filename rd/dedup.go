@@ -0,0 +1,117 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 消息去重 操作 ------------------------------------*/
+
+// Dedup 消息去重窗口，记录 ttl时间内已处理的消息ID
+// redis不可用时视为未处理，宁可重复处理也不丢消息
+type Dedup struct {
+	prefix string
+	ttl    time.Duration
+}
+
+// NewDedup 创建去重窗口，消息ID保存在 prefix:id，ttl应大于消息可能重复投递的时间范围
+func NewDedup(prefix string, ttl time.Duration) *Dedup {
+	return &Dedup{prefix: prefix, ttl: ttl}
+}
+
+// ShouldProcess 登记消息ID，首次出现时返回 true，窗口内重复出现时返回 false
+func (d *Dedup) ShouldProcess(ctx context.Context, id string) bool {
+	ok, err := client.SetNX(ctx, d.key(id), time.Now().Unix(), d.ttl).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return true
+	}
+	return ok
+}
+
+// Once 消息首次出现时执行 fn，返回 fn是否执行
+// fn返回错误或 panic时撤销登记，panic转为错误返回，消息重新投递后可再次处理
+func (d *Dedup) Once(ctx context.Context, id string, fn func(ctx context.Context) error) (ran bool, err error) {
+	if !d.ShouldProcess(ctx, id) {
+		return false, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dedup %s panic: %v", id, r)
+			logs.CtxError(ctx, err.Error())
+		}
+		if err != nil {
+			d.Forget(context.WithoutCancel(ctx), id)
+		}
+	}()
+	ran = true
+	err = fn(ctx)
+	return ran, err
+}
+
+// Forget 撤销消息ID的登记
+func (d *Dedup) Forget(ctx context.Context, id string) bool {
+	return Del(ctx, d.key(id))
+}
+
+// Seen 返回消息ID是否已在窗口内登记
+func (d *Dedup) Seen(ctx context.Context, id string) bool {
+	n, err := client.Exists(ctx, d.key(id)).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return n == 1
+}
+
+// ShouldProcessBatch 批量登记消息ID，返回与 ids一一对应的结果，true表示首次出现需要处理
+// 同一批内重复的ID只有第一个返回 true
+func (d *Dedup) ShouldProcessBatch(ctx context.Context, ids ...string) []bool {
+	result := make([]bool, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+	now := time.Now().Unix()
+	pipe := client.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.SetNX(ctx, d.key(id), now, d.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	for i, cmd := range cmds {
+		ok, err := cmd.Result()
+		result[i] = ok || err != nil
+	}
+	return result
+}
+
+// SeenBatch 批量查询消息ID是否已登记，返回与 ids一一对应的结果
+func (d *Dedup) SeenBatch(ctx context.Context, ids ...string) []bool {
+	result := make([]bool, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = d.key(id)
+	}
+	vals, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return result
+	}
+	for i, v := range vals {
+		result[i] = v != nil
+	}
+	return result
+}
+
+func (d *Dedup) key(id string) string {
+	return d.prefix + ":" + id
+}
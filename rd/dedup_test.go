@@ -0,0 +1,47 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	d := NewDedup("dedup:order", time.Minute)
+
+	if !d.ShouldProcess(ctx, "1") || d.ShouldProcess(ctx, "1") {
+		t.Fatal("first delivery should process, duplicate should not")
+	}
+
+	ran, err := d.Once(ctx, "2", func(ctx context.Context) error { return errors.New("boom") })
+	if !ran || err == nil || d.Seen(ctx, "2") {
+		t.Fatalf("failed handler should be forgotten, ran=%v err=%v", ran, err)
+	}
+	ran, err = d.Once(ctx, "2", func(ctx context.Context) error { panic("boom") })
+	if !ran || err == nil || d.Seen(ctx, "2") {
+		t.Fatalf("panicking handler should be forgotten, ran=%v err=%v", ran, err)
+	}
+	ran, err = d.Once(ctx, "2", func(ctx context.Context) error { return nil })
+	if !ran || err != nil {
+		t.Fatalf("redelivery should run, ran=%v err=%v", ran, err)
+	}
+	if ran, _ = d.Once(ctx, "2", func(ctx context.Context) error { return nil }); ran {
+		t.Fatal("processed message should not run again")
+	}
+
+	if got := d.ShouldProcessBatch(ctx, "1", "3", "3", "4"); !slices.Equal(got, []bool{false, true, false, true}) {
+		t.Fatalf("batch = %v", got)
+	}
+	if got := d.SeenBatch(ctx, "1", "4", "5"); !slices.Equal(got, []bool{true, true, false}) {
+		t.Fatalf("seen batch = %v", got)
+	}
+
+	server.FastForward(time.Minute)
+	if !d.ShouldProcess(ctx, "1") {
+		t.Fatal("id should be processable after the window")
+	}
+}
@@ -0,0 +1,316 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 诊断 操作 ------------------------------------*/
+
+// KeyStat key的统计信息
+type KeyStat struct {
+	Key  string `json:"key"`
+	Type string `json:"type,omitempty"`
+	// 热 key为采样到的访问次数，大 key为元素个数或字符串长度
+	Count int64 `json:"count"`
+	// 大 key占用的内存字节数
+	Memory int64 `json:"memory,omitempty"`
+}
+
+// hotKeyCapacity 热 key采样最多跟踪的 key数
+const hotKeyCapacity = 1024
+
+// HotKeySampler 客户端热 key采样，通过命令 hook按比例采样访问的 key
+// 最多跟踪 hotKeyCapacity个 key，超出时按 space-saving算法替换次数最少的 key，热 key的计数可能略微偏高
+type HotKeySampler struct {
+	rate float64
+
+	mu     sync.Mutex
+	counts map[string]int64
+	since  time.Time
+}
+
+// EnableHotKeySampler 为全局 redis客户端启用热 key采样，rate为采样比例，如0.01表示采样1%的命令
+func EnableHotKeySampler(rate float64) *HotKeySampler {
+	s := NewHotKeySampler(rate)
+	client.AddHook(s)
+	return s
+}
+
+// NewHotKeySampler 创建热 key采样，可通过 AddHook添加到任意 redis客户端
+func NewHotKeySampler(rate float64) *HotKeySampler {
+	return &HotKeySampler{rate: rate, counts: map[string]int64{}, since: time.Now()}
+}
+
+// Top 返回采样次数最多的 n个 key，Count已按采样比例换算为估算的访问次数
+func (s *HotKeySampler) Top(n int) []KeyStat {
+	s.mu.Lock()
+	stats := make([]KeyStat, 0, len(s.counts))
+	for key, count := range s.counts {
+		stats = append(stats, KeyStat{Key: key, Count: int64(float64(count) / s.rate)})
+	}
+	s.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Count > stats[j].Count })
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// Reset 清空采样数据，通常在每个统计周期输出报告后调用
+func (s *HotKeySampler) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = map[string]int64{}
+	s.since = time.Now()
+}
+
+// Report 输出采样周期内的热 key报告到日志并返回
+func (s *HotKeySampler) Report(ctx context.Context, n int) []KeyStat {
+	s.mu.Lock()
+	since := s.since
+	s.mu.Unlock()
+	top := s.Top(n)
+	data, _ := json.Marshal(top)
+	logs.CtxInfo(ctx, "redis hot keys since %s: %s", since.Format(time.DateTime), data)
+	return top
+}
+
+func (s *HotKeySampler) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (s *HotKeySampler) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		s.sample(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (s *HotKeySampler) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			s.sample(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (s *HotKeySampler) sample(cmd redis.Cmder) {
+	if rand.Float64() >= s.rate {
+		return
+	}
+	key := cmdFirstKey(cmd)
+	if key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.counts[key]; ok || len(s.counts) < hotKeyCapacity {
+		s.counts[key]++
+		return
+	}
+	// 替换次数最少的 key，新 key继承其次数
+	var minKey string
+	minCount := int64(-1)
+	for k, c := range s.counts {
+		if minCount < 0 || c < minCount {
+			minKey, minCount = k, c
+		}
+	}
+	delete(s.counts, minKey)
+	s.counts[key] = minCount + 1
+}
+
+// cmdFirstKey 返回命令的第一个 key，无法确定时返回空字符串
+func cmdFirstKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	switch cmd.Name() {
+	case "ping", "info", "select", "auth", "hello", "client", "config", "scan", "publish", "subscribe", "psubscribe",
+		"script", "function", "time", "dbsize", "memory", "cluster", "command":
+		return ""
+	case "eval", "evalsha", "eval_ro", "evalsha_ro", "fcall", "fcall_ro":
+		// EVAL script numkeys key [key ...]
+		if len(args) < 4 {
+			return ""
+		}
+		if n, ok := args[2].(int); ok && n == 0 {
+			return ""
+		}
+		key, _ := args[3].(string)
+		return key
+	}
+	key, _ := args[1].(string)
+	return key
+}
+
+// BigKeyOptions 大 key扫描配置
+type BigKeyOptions struct {
+	// 扫描的 key模式，默认 *
+	Match string
+	// 每次 SCAN的数量，默认500
+	Batch int64
+	// 每种类型保留的 key数量，默认10
+	Top int
+	// 每批扫描后的休眠时间，降低对线上 redis的影响
+	Sleep time.Duration
+}
+
+// BigKeyReport 大 key扫描报告
+type BigKeyReport struct {
+	Scanned  int64                `json:"scanned"`
+	Elapsed  string               `json:"elapsed"`
+	ByMemory []KeyStat            `json:"by_memory"`
+	ByType   map[string][]KeyStat `json:"by_type"`
+}
+
+// ScanBigKeys 使用 SCAN遍历 key，通过 MEMORY USAGE及 STRLEN、HLEN等命令统计每个 key的大小，返回各类型最大的 key
+func ScanBigKeys(ctx context.Context, opts BigKeyOptions) (*BigKeyReport, error) {
+	if opts.Match == "" {
+		opts.Match = "*"
+	}
+	if opts.Batch <= 0 {
+		opts.Batch = 500
+	}
+	if opts.Top <= 0 {
+		opts.Top = 10
+	}
+	start := time.Now()
+	report := &BigKeyReport{ByType: map[string][]KeyStat{}}
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, opts.Match, opts.Batch).Result()
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return nil, err
+		}
+		stats, err := keyStats(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		report.Scanned += int64(len(keys))
+		for _, stat := range stats {
+			report.ByMemory = topKeyStats(report.ByMemory, stat, opts.Top, func(s KeyStat) int64 { return s.Memory })
+			report.ByType[stat.Type] = topKeyStats(report.ByType[stat.Type], stat, opts.Top, func(s KeyStat) int64 { return s.Count })
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+		if opts.Sleep > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(opts.Sleep):
+			}
+		}
+	}
+	report.Elapsed = time.Since(start).String()
+	return report, nil
+}
+
+// Log 将报告输出到日志
+func (r *BigKeyReport) Log(ctx context.Context) {
+	logs.CtxInfo(ctx, "redis big key scan finished, scanned: %d, elapsed: %s", r.Scanned, r.Elapsed)
+	for _, stat := range r.ByMemory {
+		logs.CtxInfo(ctx, "big key by memory: %s, type: %s, memory: %d, count: %d", stat.Key, stat.Type, stat.Memory, stat.Count)
+	}
+	for typ, stats := range r.ByType {
+		for _, stat := range stats {
+			logs.CtxInfo(ctx, "big key by %s: %s, count: %d, memory: %d", typ, stat.Key, stat.Count, stat.Memory)
+		}
+	}
+}
+
+// JSON 返回 json格式的报告
+func (r *BigKeyReport) JSON() []byte {
+	data, _ := json.MarshalIndent(r, "", "  ")
+	return data
+}
+
+// keyStats 批量获取 key的类型、内存占用和元素个数
+func keyStats(ctx context.Context, keys []string) ([]KeyStat, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := client.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	memories := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+		memories[i] = pipe.MemoryUsage(ctx, key)
+	}
+	// key可能在扫描过程中被删除，单个命令的错误忽略
+	if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	pipe = client.Pipeline()
+	counts := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		switch types[i].Val() {
+		case "string":
+			counts[i] = pipe.StrLen(ctx, key)
+		case "hash":
+			counts[i] = pipe.HLen(ctx, key)
+		case "list":
+			counts[i] = pipe.LLen(ctx, key)
+		case "set":
+			counts[i] = pipe.SCard(ctx, key)
+		case "zset":
+			counts[i] = pipe.ZCard(ctx, key)
+		case "stream":
+			counts[i] = pipe.XLen(ctx, key)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	stats := make([]KeyStat, 0, len(keys))
+	for i, key := range keys {
+		typ := types[i].Val()
+		if typ == "" || typ == "none" {
+			continue
+		}
+		stat := KeyStat{Key: key, Type: typ, Memory: memories[i].Val()}
+		if counts[i] != nil {
+			stat.Count = counts[i].Val()
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// isBatchKeyError 判断是否为 key不存在或命令错误等单个命令的错误
+func isBatchKeyError(err error) bool {
+	return !isBreakerFailure(err)
+}
+
+// topKeyStats 将 stat插入按 value降序排列的 top中，并保留前 n个
+func topKeyStats(top []KeyStat, stat KeyStat, n int, value func(KeyStat) int64) []KeyStat {
+	if len(top) >= n && value(stat) <= value(top[len(top)-1]) {
+		return top
+	}
+	i := sort.Search(len(top), func(i int) bool { return value(top[i]) < value(stat) })
+	top = append(top, KeyStat{})
+	copy(top[i+1:], top[i:])
+	top[i] = stat
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
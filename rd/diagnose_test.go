@@ -0,0 +1,58 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestScanBigKeys(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		HSet(ctx, "big:hash", strconv.Itoa(i), "v")
+		HSet(ctx, "small:hash", "f", "v")
+	}
+	Set(ctx, "big:string", "0123456789")
+	RPush(ctx, "big:list", 1, 2, 3)
+
+	report, err := ScanBigKeys(ctx, BigKeyOptions{Batch: 2, Top: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 4 {
+		t.Fatalf("scanned = %d, want 4", report.Scanned)
+	}
+	if got := report.ByType["hash"]; len(got) != 1 || got[0].Key != "big:hash" || got[0].Count != 30 {
+		t.Fatalf("top hash = %+v, want big:hash with 30 fields", got)
+	}
+	if got := report.ByType["string"]; len(got) != 1 || got[0].Count != 10 {
+		t.Fatalf("top string = %+v, want length 10", got)
+	}
+}
+
+func TestHotKeySampler(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	sampler := NewHotKeySampler(1)
+	client.AddHook(sampler)
+	for i := 0; i < 5; i++ {
+		Get(ctx, "hot")
+	}
+	Get(ctx, "cold")
+	top := sampler.Top(1)
+	if len(top) != 1 || top[0].Key != "hot" || top[0].Count != 5 {
+		t.Fatalf("top = %+v, want hot with 5 hits", top)
+	}
+
+	for i := 0; i < 2*hotKeyCapacity; i++ {
+		Get(ctx, fmt.Sprintf("k%d", i))
+	}
+	if n := len(sampler.Top(3 * hotKeyCapacity)); n != hotKeyCapacity {
+		t.Fatalf("tracked keys = %d, want capped at %d", n, hotKeyCapacity)
+	}
+	if top = sampler.Top(1); top[0].Key != "hot" {
+		t.Fatalf("top = %+v, want hot to survive eviction", top)
+	}
+}
@@ -0,0 +1,442 @@
+package rd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 导出导入 操作 ------------------------------------*/
+
+// DumpRecord 导出文件中的一行，Value按类型保存
+// string为字符串，hash为对象，list、set为数组，zset为 [{member, score}]，stream为 [{id, values}]
+type DumpRecord struct {
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// 剩余过期毫秒数，0为不过期
+	TTL   int64           `json:"ttl"`
+	Value json.RawMessage `json:"value"`
+	// key或值中含有非 UTF-8数据时为 base64，此时 Key及 Value中的所有字符串（包括 hash和 stream的字段名）均为 base64编码
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// dumpEncodingBase64 DumpRecord的 base64编码
+const dumpEncodingBase64 = "base64"
+
+// DumpMember zset成员，Score与 redis的格式一致，无穷大为 inf、-inf
+type DumpMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// DumpEntry stream消息
+type DumpEntry struct {
+	ID     string            `json:"id"`
+	Values map[string]string `json:"values"`
+}
+
+// ExportOptions 导出配置
+type ExportOptions struct {
+	// key模式，默认 *
+	Match string
+	// 每次 SCAN的数量，默认500
+	Batch int64
+	// 每批导出后的休眠时间，降低对线上 redis的影响
+	Sleep time.Duration
+}
+
+// ImportOptions 导入配置
+type ImportOptions struct {
+	// 只解析并统计，不写入 redis
+	DryRun bool
+	// 将 key的前缀 RenameFrom替换为 RenameTo，不以 RenameFrom开头的 key保持不变
+	RenameFrom string
+	RenameTo   string
+	// 为 true时覆盖已存在的 key，否则跳过
+	Replace bool
+}
+
+// ImportResult 导入结果，DryRun时 Restored为将要写入的数量
+type ImportResult struct {
+	Restored int64 `json:"restored"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// Export 使用 SCAN遍历匹配的 key，按类型读取值和过期时间，以 json lines格式写入 w，返回导出的 key数量
+// 每个 key的值一次性读取，不适合导出超大 key
+func Export(ctx context.Context, w io.Writer, opts ExportOptions) (exported int64, err error) {
+	if opts.Match == "" {
+		opts.Match = "*"
+	}
+	if opts.Batch <= 0 {
+		opts.Batch = 500
+	}
+	bw := bufio.NewWriter(w)
+	// 出错时也写出已导出的记录
+	defer func() {
+		if e := bw.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	enc := json.NewEncoder(bw)
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, opts.Match, opts.Batch).Result()
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return exported, err
+		}
+		records, err := dumpKeys(ctx, keys)
+		if err != nil {
+			return exported, err
+		}
+		for _, record := range records {
+			if err = enc.Encode(record); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+		if opts.Sleep > 0 {
+			select {
+			case <-ctx.Done():
+				return exported, ctx.Err()
+			case <-time.After(opts.Sleep):
+			}
+		}
+	}
+	return exported, nil
+}
+
+// Import 从 r读取 Export导出的 json lines并写入 redis，每个 key在事务中写入值和过期时间
+func Import(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{}
+	dec := json.NewDecoder(r)
+	for {
+		var record DumpRecord
+		if err := dec.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return result, err
+		}
+		if record.Encoding == dumpEncodingBase64 {
+			key, err := base64.StdEncoding.DecodeString(record.Key)
+			if err != nil {
+				return result, fmt.Errorf("invalid key %s: %w", record.Key, err)
+			}
+			record.Key = string(key)
+		}
+		if opts.RenameFrom != "" && strings.HasPrefix(record.Key, opts.RenameFrom) {
+			record.Key = opts.RenameTo + strings.TrimPrefix(record.Key, opts.RenameFrom)
+		}
+		if !opts.Replace {
+			n, err := client.Exists(ctx, record.Key).Result()
+			if err != nil {
+				logs.CtxWarn(ctx, err.Error())
+				return result, err
+			}
+			if n > 0 {
+				result.Skipped++
+				continue
+			}
+		}
+		if opts.DryRun {
+			written, err := restoreValue(ctx, nil, &record)
+			if err != nil {
+				return result, err
+			}
+			if written {
+				result.Restored++
+			} else {
+				result.Skipped++
+			}
+			continue
+		}
+		pipe := client.TxPipeline()
+		pipe.Del(ctx, record.Key)
+		written, err := restoreValue(ctx, pipe, &record)
+		if err != nil {
+			return result, err
+		}
+		if !written {
+			// 空集合无法写入 redis
+			result.Skipped++
+			continue
+		}
+		if record.TTL > 0 {
+			pipe.PExpire(ctx, record.Key, time.Duration(record.TTL)*time.Millisecond)
+		}
+		if _, err = pipe.Exec(ctx); err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return result, err
+		}
+		result.Restored++
+	}
+}
+
+// dumpKeys 批量读取 key的类型、过期时间和值，扫描过程中被删除的 key忽略
+func dumpKeys(ctx context.Context, keys []string) ([]DumpRecord, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := client.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	pipe = client.Pipeline()
+	values := make([]redis.Cmder, len(keys))
+	for i, key := range keys {
+		switch types[i].Val() {
+		case "string":
+			values[i] = pipe.Get(ctx, key)
+		case "hash":
+			values[i] = pipe.HGetAll(ctx, key)
+		case "list":
+			values[i] = pipe.LRange(ctx, key, 0, -1)
+		case "set":
+			values[i] = pipe.SMembers(ctx, key)
+		case "zset":
+			values[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+		case "stream":
+			values[i] = pipe.XRange(ctx, key, "-", "+")
+		case "", "none":
+		default:
+			logs.CtxWarn(ctx, "redis export skip key %s of unsupported type %s", key, types[i].Val())
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	records := make([]DumpRecord, 0, len(keys))
+	for i, key := range keys {
+		if values[i] == nil || values[i].Err() != nil {
+			continue
+		}
+		// value为值的指针，便于按需改写为 base64
+		var value any
+		switch cmd := values[i].(type) {
+		case *redis.StringCmd:
+			v := cmd.Val()
+			value = &v
+		case *redis.MapStringStringCmd:
+			v := cmd.Val()
+			value = &v
+		case *redis.StringSliceCmd:
+			v := cmd.Val()
+			value = &v
+		case *redis.ZSliceCmd:
+			members := make([]DumpMember, 0, len(cmd.Val()))
+			for _, z := range cmd.Val() {
+				members = append(members, DumpMember{Member: fmt.Sprint(z.Member), Score: formatScore(z.Score)})
+			}
+			value = &members
+		case *redis.XMessageSliceCmd:
+			entries := make([]DumpEntry, 0, len(cmd.Val()))
+			for _, msg := range cmd.Val() {
+				entry := DumpEntry{ID: msg.ID, Values: make(map[string]string, len(msg.Values))}
+				for k, v := range msg.Values {
+					entry.Values[k] = fmt.Sprint(v)
+				}
+				entries = append(entries, entry)
+			}
+			value = &entries
+		}
+		record := DumpRecord{Key: key, Type: types[i].Val()}
+		if !utf8.ValidString(key) || !isUTF8Dump(value) {
+			// json会将非法的 UTF-8替换为 U+FFFD，二进制数据需编码后保存
+			_ = rewriteDumpStrings(value, func(s string) (string, error) {
+				return base64.StdEncoding.EncodeToString([]byte(s)), nil
+			})
+			record.Key = base64.StdEncoding.EncodeToString([]byte(key))
+			record.Encoding = dumpEncodingBase64
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		record.Value = data
+		if ttl := ttls[i].Val(); ttl > 0 {
+			record.TTL = ttl.Milliseconds()
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// restoreValue 解析记录的值并写入 pipe，pipe为 nil时只校验，值为空集合时返回 false
+func restoreValue(ctx context.Context, pipe redis.Pipeliner, record *DumpRecord) (bool, error) {
+	invalid := func(err error) (bool, error) {
+		return false, fmt.Errorf("invalid %s value of key %s: %w", record.Type, record.Key, err)
+	}
+	unmarshal := func(v any) error {
+		if err := json.Unmarshal(record.Value, v); err != nil {
+			return err
+		}
+		switch record.Encoding {
+		case "":
+			return nil
+		case dumpEncodingBase64:
+			return rewriteDumpStrings(v, func(s string) (string, error) {
+				b, err := base64.StdEncoding.DecodeString(s)
+				return string(b), err
+			})
+		default:
+			return fmt.Errorf("unsupported encoding %s", record.Encoding)
+		}
+	}
+	switch record.Type {
+	case "string":
+		var v string
+		if err := unmarshal(&v); err != nil {
+			return invalid(err)
+		}
+		if pipe != nil {
+			pipe.Set(ctx, record.Key, v, 0)
+		}
+		return true, nil
+	case "hash":
+		var v map[string]string
+		if err := unmarshal(&v); err != nil {
+			return invalid(err)
+		}
+		if pipe != nil && len(v) > 0 {
+			pipe.HSet(ctx, record.Key, v)
+		}
+		return len(v) > 0, nil
+	case "list", "set":
+		var v []string
+		if err := unmarshal(&v); err != nil {
+			return invalid(err)
+		}
+		if pipe != nil && len(v) > 0 {
+			args := make([]interface{}, len(v))
+			for i, s := range v {
+				args[i] = s
+			}
+			if record.Type == "list" {
+				pipe.RPush(ctx, record.Key, args...)
+			} else {
+				pipe.SAdd(ctx, record.Key, args...)
+			}
+		}
+		return len(v) > 0, nil
+	case "zset":
+		var v []DumpMember
+		if err := unmarshal(&v); err != nil {
+			return invalid(err)
+		}
+		members := make([]redis.Z, len(v))
+		for i, m := range v {
+			score, err := strconv.ParseFloat(m.Score, 64)
+			if err != nil {
+				return invalid(err)
+			}
+			members[i] = redis.Z{Member: m.Member, Score: score}
+		}
+		if pipe != nil && len(v) > 0 {
+			pipe.ZAdd(ctx, record.Key, members...)
+		}
+		return len(v) > 0, nil
+	case "stream":
+		var v []DumpEntry
+		if err := unmarshal(&v); err != nil {
+			return invalid(err)
+		}
+		if pipe != nil {
+			for _, entry := range v {
+				values := make(map[string]interface{}, len(entry.Values))
+				for k, val := range entry.Values {
+					values[k] = val
+				}
+				pipe.XAdd(ctx, &redis.XAddArgs{Stream: record.Key, ID: entry.ID, Values: values})
+			}
+		}
+		return len(v) > 0, nil
+	default:
+		return false, fmt.Errorf("unsupported type %s of key %s", record.Type, record.Key)
+	}
+}
+
+// formatScore 按 redis的格式输出 zset分数，json不支持无穷大
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// isUTF8Dump 判断值中的所有字符串是否均为合法的 UTF-8
+func isUTF8Dump(value any) bool {
+	valid := true
+	_ = rewriteDumpStrings(value, func(s string) (string, error) {
+		valid = valid && utf8.ValidString(s)
+		return s, nil
+	})
+	return valid
+}
+
+// rewriteDumpStrings 使用 fn改写值中的所有字符串，包括 hash和 stream的字段名，value为值的指针
+func rewriteDumpStrings(value any, fn func(string) (string, error)) error {
+	var err error
+	conv := func(s string) string {
+		if err != nil {
+			return s
+		}
+		var out string
+		out, err = fn(s)
+		return out
+	}
+	convMap := func(m map[string]string) map[string]string {
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			out[conv(k)] = conv(v)
+		}
+		return out
+	}
+	switch v := value.(type) {
+	case *string:
+		*v = conv(*v)
+	case *map[string]string:
+		*v = convMap(*v)
+	case *[]string:
+		for i := range *v {
+			(*v)[i] = conv((*v)[i])
+		}
+	case *[]DumpMember:
+		for i := range *v {
+			(*v)[i].Member = conv((*v)[i].Member)
+		}
+	case *[]DumpEntry:
+		for i := range *v {
+			(*v)[i].Values = convMap((*v)[i].Values)
+		}
+	}
+	return err
+}
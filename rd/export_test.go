@@ -0,0 +1,117 @@
+package rd
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestExportImport(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	SetEX(ctx, "app:str", "v", time.Minute)
+	HSet(ctx, "app:hash", "f", "1")
+	RPush(ctx, "app:list", "a", "b")
+	SAdd(ctx, "app:set", "x")
+	client.ZAdd(ctx, "app:zset", redis.Z{Member: "m", Score: 2.5})
+	client.XAdd(ctx, &redis.XAddArgs{Stream: "app:stream", ID: "1-1", Values: map[string]interface{}{"k": "v"}})
+	Set(ctx, "other", "ignored")
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, &buf, ExportOptions{Match: "app:*"})
+	if err != nil || n != 6 {
+		t.Fatalf("export = %d %v, want 6", n, err)
+	}
+	dump := buf.Bytes()
+
+	result, err := Import(ctx, bytes.NewReader(dump), ImportOptions{DryRun: true, RenameFrom: "app:", RenameTo: "copy:"})
+	if err != nil || result.Restored != 6 || server.Exists("copy:str") {
+		t.Fatalf("dry run = %+v %v, should not write", result, err)
+	}
+	if result, _ = Import(ctx, bytes.NewReader(dump), ImportOptions{}); result.Skipped != 6 {
+		t.Fatalf("import onto existing keys = %+v, want all skipped", result)
+	}
+
+	server.FlushAll()
+	result, err = Import(ctx, bytes.NewReader(dump), ImportOptions{RenameFrom: "app:", RenameTo: "copy:"})
+	if err != nil || result.Restored != 6 {
+		t.Fatalf("import = %+v %v, want 6 restored", result, err)
+	}
+	if _, v := Get(ctx, "copy:str"); v != "v" || server.TTL("copy:str") <= 0 {
+		t.Fatalf("string = %q ttl %s", v, server.TTL("copy:str"))
+	}
+	if v := HGet(ctx, "copy:hash", "f"); v != "1" {
+		t.Fatalf("hash = %q", v)
+	}
+	if v := LRange(ctx, "copy:list", 0, -1); !slices.Equal(v, []string{"a", "b"}) {
+		t.Fatalf("list = %v", v)
+	}
+	if v := SMembers(ctx, "copy:set"); !slices.Equal(v, []string{"x"}) {
+		t.Fatalf("set = %v", v)
+	}
+	if score := client.ZScore(ctx, "copy:zset", "m").Val(); score != 2.5 {
+		t.Fatalf("zset score = %v", score)
+	}
+	if msgs := client.XRange(ctx, "copy:stream", "-", "+").Val(); len(msgs) != 1 || msgs[0].ID != "1-1" {
+		t.Fatalf("stream = %v", msgs)
+	}
+}
+
+func TestExportImportBinary(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	bin := string([]byte{0xff, 0x00, 0xfe, 'a'})
+	Set(ctx, "bin:str", bin)
+	HSet(ctx, "bin:hash", bin, bin)
+	RPush(ctx, "bin:list", bin, "plain")
+	SAdd(ctx, "bin:set", bin)
+	client.ZAdd(ctx, "bin:zset", redis.Z{Member: bin, Score: 1}, redis.Z{Member: "top", Score: math.Inf(1)})
+	client.XAdd(ctx, &redis.XAddArgs{Stream: "bin:stream", ID: "1-1", Values: map[string]interface{}{bin: bin}})
+	Set(ctx, "text", "文本")
+	Set(ctx, "bin:\xff\xfe", "binary key")
+
+	var buf bytes.Buffer
+	if n, err := Export(ctx, &buf, ExportOptions{}); err != nil || n != 8 {
+		t.Fatalf("export = %d %v, want 8", n, err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"文本"}`)) {
+		t.Fatalf("utf-8 value should be exported as is: %s", buf.String())
+	}
+
+	server.FlushAll()
+	if result, err := Import(ctx, &buf, ImportOptions{}); err != nil || result.Restored != 8 {
+		t.Fatalf("import = %+v %v, want 8 restored", result, err)
+	}
+	if _, v := Get(ctx, "bin:str"); v != bin {
+		t.Fatalf("string = %q", v)
+	}
+	if v := HGetAll(ctx, "bin:hash"); v[bin] != bin {
+		t.Fatalf("hash = %q", v)
+	}
+	if v := LRange(ctx, "bin:list", 0, -1); !slices.Equal(v, []string{bin, "plain"}) {
+		t.Fatalf("list = %q", v)
+	}
+	if v := SMembers(ctx, "bin:set"); !slices.Equal(v, []string{bin}) {
+		t.Fatalf("set = %q", v)
+	}
+	if score := client.ZScore(ctx, "bin:zset", bin).Val(); score != 1 {
+		t.Fatalf("zset score = %v", score)
+	}
+	if score := client.ZScore(ctx, "bin:zset", "top").Val(); !math.IsInf(score, 1) {
+		t.Fatalf("zset inf score = %v", score)
+	}
+	if _, v := Get(ctx, "bin:\xff\xfe"); v != "binary key" {
+		t.Fatalf("binary key = %q", v)
+	}
+	if msgs := client.XRange(ctx, "bin:stream", "-", "+").Val(); len(msgs) != 1 || msgs[0].Values[bin] != bin {
+		t.Fatalf("stream = %q", msgs)
+	}
+	if _, v := Get(ctx, "text"); v != "文本" {
+		t.Fatalf("text = %q", v)
+	}
+}
@@ -0,0 +1,307 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 功能开关 操作 ------------------------------------*/
+
+// Flag 功能开关
+type Flag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Rollout为 false时开关对所有用户生效，为 true时按灰度百分比 0-100开启，
+	// 按用户ID一致性哈希，同一用户的结果稳定
+	Rollout    bool `json:"rollout"`
+	Percentage int  `json:"percentage"`
+	// 白名单用户始终开启，黑名单用户始终关闭，黑名单优先
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// 实验分组，按权重分配
+	Variants  []Variant `json:"variants"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Variant 实验分组
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// FlagStore 功能开关存储，开关保存在 redis hash中，本地保存快照并通过 Pub/Sub实时刷新，判断开关时不访问 redis
+type FlagStore struct {
+	prefix string
+	// 全量刷新间隔，防止丢失变更通知
+	interval time.Duration
+
+	mu    sync.RWMutex
+	flags map[string]*Flag
+}
+
+// NewFlagStore 创建功能开关存储
+func NewFlagStore(prefix string) *FlagStore {
+	return &FlagStore{
+		prefix:   prefix,
+		interval: time.Minute,
+		flags:    map[string]*Flag{},
+	}
+}
+
+// Start 加载全部开关并订阅变更通知，阻塞直到首次加载完成，ctx结束后停止刷新
+func (s *FlagStore) Start(ctx context.Context) error {
+	// 先确认订阅成功再全量加载，避免加载和订阅之间的变更通知丢失
+	pubsub := client.Subscribe(ctx, s.channel())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		_ = pubsub.Close()
+		return err
+	}
+	if err := s.reload(ctx); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+	go s.watch(ctx, pubsub)
+	return nil
+}
+
+// Enabled 判断开关对用户是否开启
+func (s *FlagStore) Enabled(name, userID string) bool {
+	s.mu.RLock()
+	flag := s.flags[name]
+	s.mu.RUnlock()
+	return flag.enabledFor(userID)
+}
+
+// Variant 返回用户所在的实验分组，开关未对用户开启时返回空字符串
+func (s *FlagStore) Variant(name, userID string) string {
+	s.mu.RLock()
+	flag := s.flags[name]
+	s.mu.RUnlock()
+	if !flag.enabledFor(userID) || len(flag.Variants) == 0 {
+		return ""
+	}
+	total := 0
+	for _, v := range flag.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return ""
+	}
+	bucket := int(flagHash(name+":variant:"+userID) % uint32(total))
+	for _, v := range flag.Variants {
+		if bucket < v.Weight {
+			return v.Name
+		}
+		bucket -= v.Weight
+	}
+	return ""
+}
+
+// Flag 返回开关的快照
+func (s *FlagStore) Flag(name string) (Flag, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flag, ok := s.flags[name]
+	if !ok {
+		return Flag{}, false
+	}
+	return *flag, true
+}
+
+// Flags 返回所有开关的快照
+func (s *FlagStore) Flags() []Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flags := make([]Flag, 0, len(s.flags))
+	for _, flag := range s.flags {
+		flags = append(flags, *flag)
+	}
+	return flags
+}
+
+// SetFlag 保存开关并通知所有实例刷新，operator记录到审计日志
+func (s *FlagStore) SetFlag(ctx context.Context, flag Flag, operator string) error {
+	old, _ := s.Flag(flag.Name)
+	flag.UpdatedAt = time.Now()
+	variants, _ := json.Marshal(flag.Variants)
+	pipe := client.TxPipeline()
+	pipe.Del(ctx, s.flagKey(flag.Name))
+	pipe.HSet(ctx, s.flagKey(flag.Name),
+		"enabled", strconv.FormatBool(flag.Enabled),
+		"rollout", strconv.FormatBool(flag.Rollout),
+		"percentage", flag.Percentage,
+		"allow", strings.Join(flag.Allow, ","),
+		"deny", strings.Join(flag.Deny, ","),
+		"variants", variants,
+		"updated_at", flag.UpdatedAt.Unix(),
+	)
+	pipe.SAdd(ctx, s.indexKey(), flag.Name)
+	pipe.Publish(ctx, s.channel(), flag.Name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	s.store(flag.Name, &flag)
+	before, _ := json.Marshal(old)
+	after, _ := json.Marshal(flag)
+	logs.CtxInfo(ctx, "feature flag changed, name: %s, operator: %s, before: %s, after: %s", flag.Name, operator, before, after)
+	return nil
+}
+
+// DeleteFlag 删除开关并通知所有实例刷新
+func (s *FlagStore) DeleteFlag(ctx context.Context, name, operator string) error {
+	pipe := client.TxPipeline()
+	pipe.Del(ctx, s.flagKey(name))
+	pipe.SRem(ctx, s.indexKey(), name)
+	pipe.Publish(ctx, s.channel(), name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	s.store(name, nil)
+	logs.CtxInfo(ctx, "feature flag deleted, name: %s, operator: %s", name, operator)
+	return nil
+}
+
+// watch 处理变更通知并定期全量刷新
+func (s *FlagStore) watch(ctx context.Context, pubsub *redis.PubSub) {
+	defer pubsub.Close()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			s.refresh(ctx, msg.Payload)
+		case <-ticker.C:
+			if err := s.reload(ctx); err != nil {
+				logs.CtxWarn(ctx, "feature flag reload failed: %s", err.Error())
+			}
+		}
+	}
+}
+
+// reload 全量加载开关
+func (s *FlagStore) reload(ctx context.Context) error {
+	names, err := client.SMembers(ctx, s.indexKey()).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(names))
+	for i, name := range names {
+		cmds[i] = pipe.HGetAll(ctx, s.flagKey(name))
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	flags := make(map[string]*Flag, len(names))
+	for i, name := range names {
+		if flag := parseFlag(name, cmds[i].Val()); flag != nil {
+			flags[name] = flag
+		}
+	}
+	s.mu.Lock()
+	s.flags = flags
+	s.mu.Unlock()
+	return nil
+}
+
+// refresh 重新加载单个开关
+func (s *FlagStore) refresh(ctx context.Context, name string) {
+	data, err := client.HGetAll(ctx, s.flagKey(name)).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return
+	}
+	s.store(name, parseFlag(name, data))
+}
+
+func (s *FlagStore) store(name string, flag *Flag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if flag == nil {
+		delete(s.flags, name)
+		return
+	}
+	s.flags[name] = flag
+}
+
+func (s *FlagStore) flagKey(name string) string {
+	return s.prefix + ":flag:" + name
+}
+
+func (s *FlagStore) indexKey() string {
+	return s.prefix + ":flags"
+}
+
+func (s *FlagStore) channel() string {
+	return s.prefix + ":flag-changes"
+}
+
+// enabledFor 判断开关对用户是否开启，开关不存在时视为关闭
+func (f *Flag) enabledFor(userID string) bool {
+	if f == nil || !f.Enabled {
+		return false
+	}
+	if slices.Contains(f.Deny, userID) {
+		return false
+	}
+	if slices.Contains(f.Allow, userID) {
+		return true
+	}
+	if !f.Rollout {
+		return true
+	}
+	return int(flagHash(f.Name+":"+userID)%100) < f.Percentage
+}
+
+// parseFlag 解析 hash中保存的开关，hash为空时返回 nil
+func parseFlag(name string, data map[string]string) *Flag {
+	if len(data) == 0 {
+		return nil
+	}
+	flag := &Flag{Name: name}
+	flag.Enabled, _ = strconv.ParseBool(data["enabled"])
+	flag.Percentage, _ = strconv.Atoi(data["percentage"])
+	flag.Rollout, _ = strconv.ParseBool(data["rollout"])
+	flag.Allow = splitList(data["allow"])
+	flag.Deny = splitList(data["deny"])
+	if v := data["variants"]; v != "" {
+		_ = json.Unmarshal([]byte(v), &flag.Variants)
+	}
+	updated, _ := strconv.ParseInt(data["updated_at"], 10, 64)
+	flag.UpdatedAt = time.Unix(updated, 0)
+	return flag
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// flagHash 计算用于灰度分桶的哈希值
+func flagHash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
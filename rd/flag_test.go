@@ -0,0 +1,74 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFlagStore(t *testing.T) {
+	startRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer, reader := NewFlagStore("app"), NewFlagStore("app")
+	if err := reader.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Start返回时订阅已生效，变更通知不会丢失
+	if err := writer.SetFlag(ctx, Flag{Name: "dark-mode", Enabled: true, Deny: []string{"u2"}}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if !writer.Enabled("dark-mode", "u1") || writer.Enabled("dark-mode", "u2") {
+		t.Fatal("boolean flag should be on for everyone except denied users")
+	}
+	waitFor(t, func() bool { return reader.Enabled("dark-mode", "u1") })
+
+	writer.SetFlag(ctx, Flag{Name: "none", Enabled: true, Rollout: true, Allow: []string{"vip"}}, "admin")
+	writer.SetFlag(ctx, Flag{Name: "half", Enabled: true, Rollout: true, Percentage: 50}, "admin")
+	on := 0
+	for i := 0; i < 1000; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		if writer.Enabled("none", uid) {
+			t.Fatalf("0%% rollout enabled for %s", uid)
+		}
+		if writer.Enabled("half", uid) {
+			on++
+		}
+	}
+	if !writer.Enabled("none", "vip") {
+		t.Fatal("allowed user should bypass rollout")
+	}
+	if on < 400 || on > 600 {
+		t.Fatalf("50%% rollout enabled %d of 1000", on)
+	}
+	if writer.Enabled("half", "user-1") != writer.Enabled("half", "user-1") {
+		t.Fatal("rollout should be stable per user")
+	}
+
+	writer.SetFlag(ctx, Flag{Name: "exp", Enabled: true, Variants: []Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}}, "admin")
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		seen[writer.Variant("exp", fmt.Sprintf("user-%d", i))] = true
+	}
+	if !seen["a"] || !seen["b"] || len(seen) != 2 {
+		t.Fatalf("variants = %v", seen)
+	}
+
+	if err := writer.DeleteFlag(ctx, "dark-mode", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !reader.Enabled("dark-mode", "u1") })
+}
+
+// waitFor 等待异步条件成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,223 @@
+package rd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ geo 操作 ------------------------------------*/
+
+// GeoPoint 地理位置成员
+type GeoPoint struct {
+	Member    string  `json:"member"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+// GeoResult 地理位置查询结果，Distance的单位与查询时的 Unit一致
+type GeoResult struct {
+	Member    string  `json:"member"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Distance  float64 `json:"distance"`
+}
+
+// GeoQuery 地理位置查询条件
+type GeoQuery struct {
+	// 以成员 Member为中心查询，为空时以 Longitude、Latitude为中心
+	Member    string
+	Longitude float64
+	Latitude  float64
+
+	// Radius大于0时按半径查询，否则按 Width * Height的矩形查询
+	Radius float64
+	Width  float64
+	Height float64
+	// 距离单位 m、km、ft、mi，默认 km
+	Unit string
+
+	// 排序 ASC（由近到远）或 DESC，默认不排序
+	Sort string
+	// 返回数量，0表示不限制
+	Count int
+
+	// 是否返回坐标和距离
+	WithCoord bool
+	WithDist  bool
+}
+
+// GeoAdd 添加或更新成员的地理位置，并返回新增的成员数
+func GeoAdd(ctx context.Context, key string, points ...GeoPoint) int64 {
+	if len(points) == 0 {
+		return 0
+	}
+	val, err := client.GeoAdd(ctx, key, geoLocations(points)...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// GeoAddEX 添加或更新成员的地理位置，同时在伴随有序集合中记录更新时间，配合 GeoExpire清理过期的位置
+func GeoAddEX(ctx context.Context, key string, points ...GeoPoint) bool {
+	if len(points) == 0 {
+		return true
+	}
+	now := float64(time.Now().Unix())
+	members := make([]redis.Z, 0, len(points))
+	for _, p := range points {
+		members = append(members, redis.Z{Score: now, Member: p.Member})
+	}
+	pipe := client.TxPipeline()
+	pipe.GeoAdd(ctx, key, geoLocations(points)...)
+	pipe.ZAdd(ctx, geoTimeKey(key), members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// GeoRemove 删除成员的地理位置，并返回删除的成员数
+func GeoRemove(ctx context.Context, key string, members ...string) int64 {
+	if len(members) == 0 {
+		return 0
+	}
+	data := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		data = append(data, m)
+	}
+	pipe := client.TxPipeline()
+	removed := pipe.ZRem(ctx, key, data...)
+	pipe.ZRem(ctx, geoTimeKey(key), data...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return removed.Val()
+}
+
+// GeoDist 返回两个成员之间的距离，unit为 m、km、ft、mi，默认 km
+func GeoDist(ctx context.Context, key, member1, member2, unit string) (bool, float64) {
+	val, err := client.GeoDist(ctx, key, member1, member2, unit).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false, 0
+	}
+	return true, val
+}
+
+// GeoPos 返回成员的地理位置，不存在的成员不会出现在结果中
+func GeoPos(ctx context.Context, key string, members ...string) []GeoPoint {
+	val, err := client.GeoPos(ctx, key, members...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil
+	}
+	points := make([]GeoPoint, 0, len(val))
+	for i, pos := range val {
+		if pos == nil {
+			continue
+		}
+		points = append(points, GeoPoint{Member: members[i], Longitude: pos.Longitude, Latitude: pos.Latitude})
+	}
+	return points
+}
+
+// GeoSearch 按半径或矩形查询范围内的成员
+func GeoSearch(ctx context.Context, key string, q GeoQuery) []GeoResult {
+	query := &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Member:    q.Member,
+			Longitude: q.Longitude,
+			Latitude:  q.Latitude,
+			Sort:      q.Sort,
+			Count:     q.Count,
+		},
+		WithCoord: q.WithCoord,
+		WithDist:  q.WithDist,
+	}
+	if q.Radius > 0 {
+		query.Radius = q.Radius
+		query.RadiusUnit = geoUnit(q.Unit)
+	} else {
+		query.BoxWidth = q.Width
+		query.BoxHeight = q.Height
+		query.BoxUnit = geoUnit(q.Unit)
+	}
+	val, err := client.GeoSearchLocation(ctx, key, query).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil
+	}
+	results := make([]GeoResult, 0, len(val))
+	for _, loc := range val {
+		results = append(results, GeoResult{
+			Member:    loc.Name,
+			Longitude: loc.Longitude,
+			Latitude:  loc.Latitude,
+			Distance:  loc.Dist,
+		})
+	}
+	return results
+}
+
+// GeoNearby 查询以经纬度为中心 radius范围内由近到远的 count个成员，并返回坐标和距离
+func GeoNearby(ctx context.Context, key string, longitude, latitude, radius float64, unit string, count int) []GeoResult {
+	return GeoSearch(ctx, key, GeoQuery{
+		Longitude: longitude,
+		Latitude:  latitude,
+		Radius:    radius,
+		Unit:      unit,
+		Sort:      "ASC",
+		Count:     count,
+		WithCoord: true,
+		WithDist:  true,
+	})
+}
+
+// geoExpireScript 删除更新时间早于截止时间的成员，KEYS[1]为位置 key，KEYS[2]为时间 key，ARGV[1]为截止时间
+var geoExpireScript = redis.NewScript(`
+local members = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for i = 1, #members, 500 do
+	local batch = {unpack(members, i, math.min(i + 499, #members))}
+	redis.call('ZREM', KEYS[1], unpack(batch))
+	redis.call('ZREM', KEYS[2], unpack(batch))
+end
+return #members
+`)
+
+// GeoExpire 删除超过 ex未通过 GeoAddEX更新过位置的成员，并返回删除的成员数
+func GeoExpire(ctx context.Context, key string, ex time.Duration) int64 {
+	cutoff := strconv.FormatInt(time.Now().Add(-ex).Unix(), 10)
+	val, err := geoExpireScript.Run(ctx, client, []string{key, geoTimeKey(key)}, cutoff).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return val
+}
+
+// geoTimeKey 返回记录位置更新时间的伴随有序集合 key
+func geoTimeKey(key string) string {
+	return key + ":ts"
+}
+
+// geoLocations 将 GeoPoint转换为 redis.GeoLocation
+func geoLocations(points []GeoPoint) []*redis.GeoLocation {
+	locations := make([]*redis.GeoLocation, 0, len(points))
+	for _, p := range points {
+		locations = append(locations, &redis.GeoLocation{Name: p.Member, Longitude: p.Longitude, Latitude: p.Latitude})
+	}
+	return locations
+}
+
+// geoUnit 返回距离单位，默认 km
+func geoUnit(unit string) string {
+	if unit == "" {
+		return "km"
+	}
+	return unit
+}
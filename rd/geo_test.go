@@ -0,0 +1,39 @@
+package rd
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGeo(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	points := []GeoPoint{
+		{Member: "palermo", Longitude: 13.361389, Latitude: 38.115556},
+		{Member: "catania", Longitude: 15.087269, Latitude: 37.502669},
+	}
+	if n := GeoAdd(ctx, "sicily", points...); n != 2 {
+		t.Fatalf("added = %d, want 2", n)
+	}
+	if ok, d := GeoDist(ctx, "sicily", "palermo", "catania", "km"); !ok || math.Abs(d-166.27) > 0.1 {
+		t.Fatalf("dist = %v %f, want about 166.27 km", ok, d)
+	}
+	if pos := GeoPos(ctx, "sicily", "palermo", "missing"); len(pos) != 1 || pos[0].Member != "palermo" || math.Abs(pos[0].Longitude-13.361389) > 1e-4 {
+		t.Fatalf("pos = %+v", pos)
+	}
+
+	GeoAddEX(ctx, "drivers", GeoPoint{Member: "d1", Longitude: 13.36, Latitude: 38.11}, GeoPoint{Member: "d2", Longitude: 15.08, Latitude: 37.5})
+	// d1超过一分钟未更新位置
+	_, _ = server.ZAdd(geoTimeKey("drivers"), float64(time.Now().Add(-2*time.Minute).Unix()), "d1")
+	if n := GeoExpire(ctx, "drivers", time.Minute); n != 1 {
+		t.Fatalf("expired = %d, want 1", n)
+	}
+	if pos := GeoPos(ctx, "drivers", "d1", "d2"); len(pos) != 1 || pos[0].Member != "d2" {
+		t.Fatalf("drivers after expire = %+v, want only d2", pos)
+	}
+	if n := GeoRemove(ctx, "drivers", "d2"); n != 1 || server.Exists(geoTimeKey("drivers")) {
+		t.Fatalf("removed = %d, want the position and update time removed", n)
+	}
+}
@@ -0,0 +1,153 @@
+package rd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/oho-panda/utils/v2/res"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 健康检查 操作 ------------------------------------*/
+
+// HealthStatus redis健康状态
+type HealthStatus struct {
+	Up bool `json:"up"`
+	// PING耗时
+	Latency string `json:"latency"`
+	// master或 slave
+	Role      string           `json:"role,omitempty"`
+	Pool      *redis.PoolStats `json:"pool,omitempty"`
+	InFlight  int64            `json:"in_flight"`
+	Error     string           `json:"error,omitempty"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// HealthCheck 检查 redis是否可用，返回延迟、连接池统计和节点角色
+func HealthCheck(ctx context.Context) *HealthStatus {
+	status := &HealthStatus{CheckedAt: time.Now(), InFlight: inflight.count.Load()}
+	if client == nil {
+		status.Error = "redis client not initialized"
+		return status
+	}
+	status.Pool = client.PoolStats()
+	start := time.Now()
+	if err := client.Ping(ctx).Err(); err != nil {
+		status.Latency = time.Since(start).String()
+		status.Error = err.Error()
+		return status
+	}
+	status.Latency = time.Since(start).String()
+	status.Up = true
+	// 托管 redis可能禁用 INFO命令，获取不到角色不影响健康状态
+	if info, err := client.Info(ctx, "replication").Result(); err == nil {
+		status.Role = infoField(info, "role")
+	}
+	return status
+}
+
+// HealthHandler 返回 redis健康检查的 http处理函数，可用于就绪探针，不可用时返回503
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		status := HealthCheck(ctx)
+		if !status.Up {
+			writeResponse(w, http.StatusServiceUnavailable, res.Res(http.StatusServiceUnavailable, status, status.Error))
+			return
+		}
+		writeResponse(w, http.StatusOK, res.SuccessOfData(status))
+	})
+}
+
+// Shutdown 停止接收新命令，等待执行中的命令完成后关闭 redis客户端、分片客户端及 EnableReplicas创建的从节点客户端
+// ctx结束时不再等待，直接关闭
+// 只等待 InitRedisClient创建的客户端上的命令，通过 SetRedisClient设置的客户端不统计执行中的命令
+func Shutdown(ctx context.Context) error {
+	inflight.closing.Store(true)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for inflight.count.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			logs.CtxWarn(ctx, "redis shutdown timeout, %d commands still in flight", inflight.count.Load())
+			return closeClients()
+		case <-ticker.C:
+		}
+	}
+	return closeClients()
+}
+
+func closeClients() error {
+	for _, r := range globalReplicas() {
+		r.Close()
+	}
+	var err error
+	if client != nil {
+		err = client.Close()
+	}
+	if ring != nil {
+		if e := ring.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// infoField 从 INFO命令的输出中获取字段值
+func infoField(info, field string) string {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), ":"); ok && k == field {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// inflight 统计执行中的命令
+var inflight = &inflightHook{}
+
+type inflightHook struct {
+	count   atomic.Int64
+	closing atomic.Bool
+}
+
+func (h *inflightHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *inflightHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		// 先计数再检查，保证 Shutdown看到计数为0后不会再有命令开始执行
+		h.count.Add(1)
+		defer h.count.Add(-1)
+		if h.closing.Load() {
+			cmd.SetErr(redis.ErrClosed)
+			return redis.ErrClosed
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *inflightHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.count.Add(1)
+		defer h.count.Add(-1)
+		if h.closing.Load() {
+			for _, cmd := range cmds {
+				cmd.SetErr(redis.ErrClosed)
+			}
+			return redis.ErrClosed
+		}
+		return next(ctx, cmds)
+	}
+}
@@ -0,0 +1,46 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestHealthHandler(t *testing.T) {
+	server := startRedis(t)
+	w := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	server.Close()
+	w = httptest.NewRecorder()
+	HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	startRedis(t)
+	router := EnableReplicas([]string{miniredis.RunT(t).Addr()}, "", time.Second, ReplicaRoundRobin)
+	client.AddHook(inflight)
+	t.Cleanup(func() { inflight.closing.Store(false) })
+	ctx := context.Background()
+	Set(ctx, "k", "v")
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Get(ctx, "k"); ok {
+		t.Fatal("commands after shutdown should fail")
+	}
+	if err := router.replicas[0].client.Ping(ctx).Err(); !errors.Is(err, redis.ErrClosed) || len(globalReplicas()) != 0 {
+		t.Fatalf("replica ping err = %v, want the replica client closed", err)
+	}
+}
@@ -0,0 +1,259 @@
+package rd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/oho-panda/utils/v2/res"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 幂等 操作 ------------------------------------*/
+
+// IdempotencyHeader 客户端携带幂等键的请求头
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyState 幂等键的状态
+type IdempotencyState int
+
+const (
+	// IdempotencyUnknown 状态未知，检查失败时返回，不能执行业务
+	IdempotencyUnknown IdempotencyState = iota
+	// IdempotencyNew 首次请求，已加锁，可以执行业务
+	IdempotencyNew
+	// IdempotencyProcessing 相同幂等键的请求正在处理中
+	IdempotencyProcessing
+	// IdempotencyCompleted 已处理完成，可以重放保存的响应
+	IdempotencyCompleted
+	// IdempotencyMismatch 幂等键相同但请求内容不同
+	IdempotencyMismatch
+)
+
+// IdempotencyRecord 保存的响应
+type IdempotencyRecord struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// idempotencyBeginScript 检查并锁定幂等键
+// KEYS[1] 幂等键，ARGV[1] 请求指纹，ARGV[2] 锁定毫秒数，ARGV[3] 锁的持有者
+// 返回 {状态, 响应状态码, 响应内容, 响应头}
+var idempotencyBeginScript = redis.NewScript(`
+local record = redis.call('HMGET', KEYS[1], 'fingerprint', 'state', 'status', 'body', 'header')
+if not record[1] then
+	redis.call('HSET', KEYS[1], 'fingerprint', ARGV[1], 'state', 'processing', 'owner', ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return {1, 0, '', ''}
+end
+if record[1] ~= ARGV[1] then
+	return {4, 0, '', ''}
+end
+if record[2] == 'processing' then
+	return {2, 0, '', ''}
+end
+return {3, tonumber(record[3]), record[4], record[5] or ''}
+`)
+
+// idempotencyCompleteScript 保存响应，仅在仍由 owner持有且处于处理中时生效
+// KEYS[1] 幂等键，ARGV[1] 锁的持有者，ARGV[2] 响应状态码，ARGV[3] 响应内容，ARGV[4] 响应头 json，ARGV[5] 保存毫秒数
+var idempotencyCompleteScript = redis.NewScript(`
+local record = redis.call('HMGET', KEYS[1], 'owner', 'state')
+if record[1] ~= ARGV[1] or record[2] ~= 'processing' then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'state', 'done', 'status', ARGV[2], 'body', ARGV[3], 'header', ARGV[4])
+redis.call('HDEL', KEYS[1], 'owner')
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// idempotencyAbortScript 仍由 owner持有且处于处理中时删除幂等键，避免删除锁过期后其他请求的锁
+// KEYS[1] 幂等键，ARGV[1] 锁的持有者
+var idempotencyAbortScript = redis.NewScript(`
+local record = redis.call('HMGET', KEYS[1], 'owner', 'state')
+if record[1] ~= ARGV[1] or record[2] ~= 'processing' then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// Idempotency 基于 redis的幂等键存储
+type Idempotency struct {
+	prefix string
+	// 处理中的锁定时长，超时后允许重试
+	lockTTL time.Duration
+	// 响应保存时长
+	ttl time.Duration
+}
+
+// NewIdempotency 创建幂等键存储，lockTTL为处理中的最长锁定时长，ttl为响应保存时长
+func NewIdempotency(prefix string, lockTTL, ttl time.Duration) *Idempotency {
+	return &Idempotency{
+		prefix:  prefix,
+		lockTTL: lockTTL,
+		ttl:     ttl,
+	}
+}
+
+// Begin 检查幂等键，返回状态及已保存的响应，首次请求时以 owner锁定幂等键
+// owner用于 Complete和 Abort校验锁的持有者，每个请求应使用不同的随机值
+func (i *Idempotency) Begin(ctx context.Context, key, fingerprint, owner string) (IdempotencyState, *IdempotencyRecord, error) {
+	val, err := idempotencyBeginScript.Run(ctx, client, []string{i.key(key)}, fingerprint, i.lockTTL.Milliseconds(), owner).Slice()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return IdempotencyUnknown, nil, err
+	}
+	state := IdempotencyState(val[0].(int64))
+	if state != IdempotencyCompleted {
+		return state, nil, nil
+	}
+	body, _ := val[2].(string)
+	record := &IdempotencyRecord{Status: int(val[1].(int64)), Body: []byte(body)}
+	if header, _ := val[3].(string); header != "" {
+		if err = json.Unmarshal([]byte(header), &record.Header); err != nil {
+			logs.CtxWarn(ctx, err.Error())
+		}
+	}
+	return state, record, nil
+}
+
+// Complete 保存处理完成后的响应，锁已过期、已被其他请求持有或已完成时不保存并返回 false
+func (i *Idempotency) Complete(ctx context.Context, key, owner string, record *IdempotencyRecord) bool {
+	header, _ := json.Marshal(record.Header)
+	val, err := idempotencyCompleteScript.Run(ctx, client, []string{i.key(key)},
+		owner, record.Status, record.Body, header, i.ttl.Milliseconds()).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return val == 1
+}
+
+// Abort 处理失败时删除幂等键，允许客户端重试，锁已不由 owner持有时不删除并返回 false
+func (i *Idempotency) Abort(ctx context.Context, key, owner string) bool {
+	val, err := idempotencyAbortScript.Run(ctx, client, []string{i.key(key)}, owner).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return val == 1
+}
+
+// Middleware 幂等中间件，对携带 Idempotency-Key请求头的请求生效
+// 首次请求正常处理并保存响应的状态码、响应头和内容，重试时直接重放保存的响应，请求内容不一致时返回422
+// 处理结果为5xx时不保存响应，允许客户端重试，redis不可用时无法保证幂等，返回503
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, res.FailOfMessage("读取请求失败"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+		owner := newToken()
+
+		state, record, err := i.Begin(ctx, key, fingerprint, owner)
+		if err != nil {
+			writeResponse(w, http.StatusServiceUnavailable, res.Res(http.StatusServiceUnavailable, nil, "幂等检查失败，请稍后重试"))
+			return
+		}
+		switch state {
+		case IdempotencyProcessing:
+			writeResponse(w, http.StatusConflict, res.Res(http.StatusConflict, nil, "请求正在处理中"))
+			return
+		case IdempotencyMismatch:
+			writeResponse(w, http.StatusUnprocessableEntity, res.Res(http.StatusUnprocessableEntity, nil, "幂等键与请求内容不匹配"))
+			return
+		case IdempotencyCompleted:
+			for k, v := range record.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			_, _ = w.Write(record.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				i.Abort(context.WithoutCancel(ctx), key, owner)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			i.Abort(context.WithoutCancel(ctx), key, owner)
+			return
+		}
+		if rec.header == nil {
+			rec.header = w.Header().Clone()
+		}
+		i.Complete(context.WithoutCancel(ctx), key, owner, &IdempotencyRecord{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+	})
+}
+
+func (i *Idempotency) key(key string) string {
+	return i.prefix + ":" + key
+}
+
+// requestFingerprint 根据请求方法、路径和内容计算请求指纹
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder 记录响应状态码、响应头和内容
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.header = r.ResponseWriter.Header().Clone()
+		r.wroteHeader = true
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// writeResponse 以 json格式写出 res.Response
+func writeResponse(w http.ResponseWriter, status int, response *res.Response) {
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
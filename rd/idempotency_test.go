@@ -0,0 +1,91 @@
+package rd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	idem := NewIdempotency("idem", time.Minute, time.Hour)
+
+	if state, _, err := idem.Begin(ctx, "k1", "fp", "o1"); err != nil || state != IdempotencyNew {
+		t.Fatalf("first begin = %v %v, want new", state, err)
+	}
+	if state, _, _ := idem.Begin(ctx, "k1", "fp", "o2"); state != IdempotencyProcessing {
+		t.Fatalf("second begin = %v, want processing", state)
+	}
+	if state, _, _ := idem.Begin(ctx, "k1", "other", "o2"); state != IdempotencyMismatch {
+		t.Fatalf("other fingerprint = %v, want mismatch", state)
+	}
+	if idem.Complete(ctx, "k1", "o2", &IdempotencyRecord{Status: 201}) || idem.Abort(ctx, "k1", "o2") {
+		t.Fatal("only the owner may complete or abort")
+	}
+	record := &IdempotencyRecord{Status: 201, Header: http.Header{"Location": {"/orders/1"}}, Body: []byte("ok")}
+	if !idem.Complete(ctx, "k1", "o1", record) {
+		t.Fatal("complete while processing should succeed")
+	}
+	if idem.Complete(ctx, "k1", "o1", &IdempotencyRecord{Status: 500}) {
+		t.Fatal("complete after completed should not overwrite")
+	}
+	state, replay, _ := idem.Begin(ctx, "k1", "fp", "o3")
+	if state != IdempotencyCompleted || replay.Status != 201 || string(replay.Body) != "ok" || replay.Header.Get("Location") != "/orders/1" {
+		t.Fatalf("replay = %v %+v", state, replay)
+	}
+
+	// 锁过期后其他请求重新加锁，原请求不能删除新锁
+	idem.Begin(ctx, "k2", "fp", "old")
+	server.FastForward(2 * time.Minute)
+	idem.Begin(ctx, "k2", "fp", "new")
+	if idem.Abort(ctx, "k2", "old") || !server.Exists("idem:k2") {
+		t.Fatal("stale owner should not delete the new lock")
+	}
+
+	server.Close()
+	if state, _, err := idem.Begin(ctx, "k3", "fp", "o"); err == nil || state != IdempotencyUnknown {
+		t.Fatalf("begin without redis = %v %v, want unknown with error", state, err)
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	server := startRedis(t)
+	calls := 0
+	handler := NewIdempotency("idem", time.Minute, time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+	do := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set(IdempotencyHeader, "pay-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("amount=1"); rec.Code != http.StatusCreated || rec.Body.String() != "created" {
+		t.Fatalf("first = %d %q", rec.Code, rec.Body.String())
+	}
+	rec := do("amount=1")
+	if rec.Code != http.StatusCreated || rec.Body.String() != "created" || calls != 1 {
+		t.Fatalf("replay = %d %q, calls %d", rec.Code, rec.Body.String(), calls)
+	}
+	if rec.Header().Get("Content-Type") != "text/plain" || rec.Header().Get("Location") != "/orders/1" || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay headers = %v", rec.Header())
+	}
+	if rec = do("amount=2"); rec.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Fatalf("mismatch = %d, calls %d", rec.Code, calls)
+	}
+
+	server.Close()
+	if rec = do("amount=1"); rec.Code != http.StatusServiceUnavailable || calls != 1 {
+		t.Fatalf("redis down = %d, calls %d, want 503 without running the handler", rec.Code, calls)
+	}
+}
@@ -0,0 +1,312 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 分布式ID 操作 ------------------------------------*/
+
+// snowflake 各部分位数：41位毫秒时间戳 + 10位机器ID + 12位序列号
+const (
+	workerBits   = 10
+	sequenceBits = 12
+	maxWorkerID  = 1<<workerBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+	// 时钟回拨在该范围内时等待追上，超过则返回错误
+	maxBackward = 5 * time.Millisecond
+)
+
+// 机器ID租约的默认值和最小值
+const (
+	defaultWorkerLease = 30 * time.Second
+	minWorkerLease     = time.Second
+)
+
+// leaseSafety 租约安全余量，距上次续期超过 lease-lease/leaseSafety时停止生成ID，
+// 避免本地租约尚未判定过期而 redis中的租约已到期并被其他实例占用
+const leaseSafety = 5
+
+// SnowflakeEpoch 时间戳起始时间 2024-01-01 00:00:00 UTC
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrNoWorkerID 所有机器ID都已被占用
+	ErrNoWorkerID = errors.New("snowflake: no worker id available")
+	// ErrWorkerLost 机器ID租约已丢失，暂停生成ID直到重新获取
+	ErrWorkerLost = errors.New("snowflake: worker id lease lost")
+	// ErrClockBackward 时钟回拨超过允许范围
+	ErrClockBackward = errors.New("snowflake: clock moved backwards")
+)
+
+// workerAcquireScript 从0开始依次尝试占用空闲的机器ID，返回 -1表示没有空闲的机器ID
+// KEYS[1] 机器ID key前缀，ARGV[1] 占用者，ARGV[2] 租约毫秒数，ARGV[3] 最大机器ID
+var workerAcquireScript = redis.NewScript(`
+for id = 0, tonumber(ARGV[3]) do
+	if redis.call('SET', KEYS[1] .. id, ARGV[1], 'NX', 'PX', ARGV[2]) then
+		return id
+	end
+end
+return -1
+`)
+
+// workerRenewScript 机器ID仍由自己占用时续期，返回0表示租约已丢失
+// KEYS[1] 机器ID key，ARGV[1] 占用者，ARGV[2] 租约毫秒数
+var workerRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// workerReleaseScript 机器ID仍由自己占用时释放
+// KEYS[1] 机器ID key，ARGV[1] 占用者
+var workerReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Snowflake 雪花算法ID生成器，机器ID通过 redis租约分配，崩溃实例的机器ID在租约到期后回收
+type Snowflake struct {
+	prefix string
+	owner  string
+	lease  time.Duration
+
+	mu       sync.Mutex
+	workerID int64
+	valid    bool
+	lastTs   int64
+	sequence int64
+	// 最近一次成功续期的时间
+	renewedAt time.Time
+	cancel    context.CancelFunc
+}
+
+// NewSnowflake 创建ID生成器，从 redis租用机器ID并启动心跳续期，ctx结束或调用 Close后释放机器ID
+// lease为机器ID租约时长，不大于0时默认30秒，不能小于1秒
+func NewSnowflake(ctx context.Context, prefix string, lease time.Duration) (*Snowflake, error) {
+	if lease <= 0 {
+		lease = defaultWorkerLease
+	}
+	if lease < minWorkerLease {
+		return nil, fmt.Errorf("snowflake: lease %s is shorter than %s", lease, minWorkerLease)
+	}
+	host, _ := os.Hostname()
+	s := &Snowflake{
+		prefix: prefix,
+		owner:  host + ":" + newToken(),
+		lease:  lease,
+	}
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	hbCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	go s.heartbeat(hbCtx)
+	return s, nil
+}
+
+// NextID 生成下一个ID
+func (s *Snowflake) NextID() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.valid || time.Since(s.renewedAt) >= s.safeLease() {
+		return 0, ErrWorkerLost
+	}
+	now := time.Since(SnowflakeEpoch).Milliseconds()
+	if now < s.lastTs {
+		backward := time.Duration(s.lastTs-now) * time.Millisecond
+		if backward > maxBackward {
+			return 0, fmt.Errorf("%w: %s", ErrClockBackward, backward)
+		}
+		time.Sleep(backward)
+		now = time.Since(SnowflakeEpoch).Milliseconds()
+	}
+	if now == s.lastTs {
+		s.sequence = (s.sequence + 1) & maxSequence
+		if s.sequence == 0 {
+			// 当前毫秒序列号用完，等待下一毫秒
+			for now <= s.lastTs {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Since(SnowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTs = now
+	return now<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence, nil
+}
+
+// WorkerID 返回当前租用的机器ID
+func (s *Snowflake) WorkerID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workerID
+}
+
+// Close 停止心跳并释放机器ID
+func (s *Snowflake) Close(ctx context.Context) {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.valid {
+		if err := workerReleaseScript.Run(ctx, client, []string{s.workerKey(s.workerID)}, s.owner).Err(); err != nil {
+			logs.CtxWarn(ctx, err.Error())
+		}
+		s.valid = false
+	}
+}
+
+// ParseID 解析ID的生成时间、机器ID和序列号
+func ParseID(id int64) (time.Time, int64, int64) {
+	ts := id >> (workerBits + sequenceBits)
+	return SnowflakeEpoch.Add(time.Duration(ts) * time.Millisecond),
+		id >> sequenceBits & maxWorkerID,
+		id & maxSequence
+}
+
+func (s *Snowflake) acquire(ctx context.Context) error {
+	// 租约从发送命令前开始计算，保证本地判断的到期时间不晚于 redis
+	sentAt := time.Now()
+	id, err := workerAcquireScript.Run(ctx, client, []string{s.prefix + ":worker:"}, s.owner, s.lease.Milliseconds(), maxWorkerID).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	if id < 0 {
+		return ErrNoWorkerID
+	}
+	s.mu.Lock()
+	s.workerID = id
+	s.valid = true
+	s.renewedAt = sentAt
+	s.mu.Unlock()
+	logs.CtxInfo(ctx, "snowflake worker id acquired, prefix: %s, worker: %d", s.prefix, id)
+	return nil
+}
+
+// heartbeat 定期续期机器ID租约，租约丢失后暂停生成ID并重新获取
+func (s *Snowflake) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		valid, workerID, renewedAt := s.valid, s.workerID, s.renewedAt
+		s.mu.Unlock()
+		if !valid {
+			_ = s.acquire(ctx)
+			continue
+		}
+		sentAt := time.Now()
+		val, err := workerRenewScript.Run(ctx, client, []string{s.workerKey(workerID)}, s.owner, s.lease.Milliseconds()).Int64()
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+		}
+		if val == 1 {
+			s.mu.Lock()
+			s.renewedAt = sentAt
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil && time.Since(renewedAt) < s.safeLease() {
+			// redis暂时不可用但租约尚未到期，继续使用当前机器ID
+			continue
+		}
+		s.mu.Lock()
+		s.valid = false
+		s.mu.Unlock()
+		logs.CtxWarn(ctx, "snowflake worker id lease lost, prefix: %s, worker: %d", s.prefix, workerID)
+	}
+}
+
+// safeLease 扣除安全余量后的租约时长
+func (s *Snowflake) safeLease() time.Duration {
+	return s.lease - s.lease/leaseSafety
+}
+
+func (s *Snowflake) workerKey(id int64) string {
+	return fmt.Sprintf("%s:worker:%d", s.prefix, id)
+}
+
+// SegmentAllocator 号段分配器，每次通过 IncrBy从 redis预取 step个连续号码，适用于订单号等稠密数字ID
+type SegmentAllocator struct {
+	key   string
+	step  int64
+	daily bool
+	width int
+
+	mu   sync.Mutex
+	date string
+	cur  int64
+	max  int64
+}
+
+// NewSegmentAllocator 创建号段分配器，step越大访问 redis越少，但实例重启时丢弃的号码越多
+func NewSegmentAllocator(key string, step int64) *SegmentAllocator {
+	return &SegmentAllocator{key: key, step: step}
+}
+
+// NewDailySegmentAllocator 创建按天重置的号段分配器，配合 NextNo生成带日期前缀的编号
+func NewDailySegmentAllocator(key string, step int64, width int) *SegmentAllocator {
+	return &SegmentAllocator{key: key, step: step, daily: true, width: width}
+}
+
+// Next 返回下一个号码
+func (a *SegmentAllocator) Next(ctx context.Context) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.next(ctx)
+}
+
+// NextNo 返回带日期前缀的编号，如 20261019000123
+func (a *SegmentAllocator) NextNo(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, err := a.next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%0*d", a.date, a.width, n), nil
+}
+
+func (a *SegmentAllocator) next(ctx context.Context) (int64, error) {
+	key := a.key
+	if a.daily {
+		date := time.Now().Format("20060102")
+		if date != a.date {
+			// 跨天后丢弃当前号段
+			a.date = date
+			a.cur, a.max = 0, 0
+		}
+		key = a.key + ":" + date
+	}
+	if a.cur >= a.max {
+		pipe := client.TxPipeline()
+		end := pipe.IncrBy(ctx, key, a.step)
+		if a.daily {
+			pipe.Expire(ctx, key, 48*time.Hour)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return 0, err
+		}
+		a.cur, a.max = end.Val()-a.step, end.Val()
+	}
+	a.cur++
+	return a.cur, nil
+}
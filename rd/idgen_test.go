@@ -0,0 +1,56 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnowflakeLeaseExpiry(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	s, err := NewSnowflake(ctx, "idgen", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	a, err := s.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := s.NextID()
+	if b <= a {
+		t.Fatalf("ids not increasing: %d %d", a, b)
+	}
+	if _, worker, _ := ParseID(a); worker != s.WorkerID() {
+		t.Fatalf("worker = %d, want %d", worker, s.WorkerID())
+	}
+
+	// 距上次续期进入安全余量后停止生成
+	s.mu.Lock()
+	s.renewedAt = time.Now().Add(-s.safeLease())
+	s.mu.Unlock()
+	if _, err = s.NextID(); !errors.Is(err, ErrWorkerLost) {
+		t.Fatalf("err = %v, want ErrWorkerLost", err)
+	}
+}
+
+func TestSnowflakeLease(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	if _, err := NewSnowflake(ctx, "idgen", time.Millisecond); err == nil {
+		t.Fatal("a lease shorter than a second should be rejected")
+	}
+	s, err := NewSnowflake(ctx, "idgen", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("idgen:worker:0"); ttl != defaultWorkerLease {
+		t.Fatalf("lease ttl = %s, want the default %s", ttl, defaultWorkerLease)
+	}
+	s.Close(ctx)
+	if server.Exists("idgen:worker:0") {
+		t.Fatal("close should release the worker id")
+	}
+}
@@ -0,0 +1,268 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 消息收件箱 操作 ------------------------------------*/
+
+// InboxMessage 收件箱消息
+type InboxMessage struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// 业务自定义数据，通常为 json
+	Extra     string    `json:"extra,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read"`
+}
+
+// InboxOptions 收件箱配置
+type InboxOptions struct {
+	// 每个用户保留的最大消息数，超出时删除最早的消息，默认500
+	Cap int64
+	// 消息内容及收件箱的保留时长，收件箱在每次收到消息时续期，默认30天
+	TTL time.Duration
+}
+
+// Inbox 用户消息收件箱
+// prefix:inbox:uid 按时间排序的全部消息ID，prefix:unread:uid 未读消息ID，prefix:msg:id 消息内容
+// 广播消息只保存一份内容，各用户收件箱中只保存消息ID
+type Inbox struct {
+	prefix string
+	opts   InboxOptions
+}
+
+// inboxAddScript 将消息ID加入收件箱和未读集合，超出上限时同时从两者中删除最早的消息，返回未读数
+// KEYS[1] 收件箱，KEYS[2] 未读集合，ARGV[1] 消息ID，ARGV[2] 毫秒时间戳，ARGV[3] 上限，ARGV[4] 过期毫秒数
+var inboxAddScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+local over = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[3])
+if over > 0 then
+	local old = redis.call('ZRANGE', KEYS[1], 0, over - 1)
+	redis.call('ZREM', KEYS[1], unpack(old))
+	redis.call('ZREM', KEYS[2], unpack(old))
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+return redis.call('ZCARD', KEYS[2])
+`)
+
+// NewInbox 创建收件箱
+func NewInbox(prefix string, opts InboxOptions) *Inbox {
+	if opts.Cap <= 0 {
+		opts.Cap = 500
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * 24 * time.Hour
+	}
+	return &Inbox{prefix: prefix, opts: opts}
+}
+
+// Send 向用户发送消息，ID为空时自动生成，返回是否成功
+func (in *Inbox) Send(ctx context.Context, uid string, msg *InboxMessage) bool {
+	return in.Broadcast(ctx, []string{uid}, msg) == 1
+}
+
+// Broadcast 向多个用户发送同一条消息，消息内容只保存一份，按批使用 pipeline写入各用户收件箱，返回成功写入的用户数
+func (in *Inbox) Broadcast(ctx context.Context, uids []string, msg *InboxMessage) int {
+	if len(uids) == 0 {
+		return 0
+	}
+	if msg.ID == "" {
+		msg.ID = newToken()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	pipe := client.TxPipeline()
+	pipe.HSet(ctx, in.msgKey(msg.ID), map[string]interface{}{
+		"kind":       msg.Kind,
+		"title":      msg.Title,
+		"content":    msg.Content,
+		"extra":      msg.Extra,
+		"created_at": msg.CreatedAt.UnixMilli(),
+	})
+	pipe.PExpire(ctx, in.msgKey(msg.ID), in.opts.TTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return 0
+	}
+	// 预先加载脚本，pipeline中使用 EVALSHA
+	if err := inboxAddScript.Load(ctx, client).Err(); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return 0
+	}
+	sent := 0
+	for i := 0; i < len(uids); i += 500 {
+		batch := uids[i:min(i+500, len(uids))]
+		n, missing := in.addBatch(ctx, batch, msg, false)
+		if len(missing) > 0 {
+			// 脚本在加载后被清空（SCRIPT FLUSH或主从切换），改用 EVAL重试
+			m, _ := in.addBatch(ctx, missing, msg, true)
+			n += m
+		}
+		sent += n
+	}
+	return sent
+}
+
+// addBatch 使用 pipeline将消息写入一批用户的收件箱，返回成功数及因 NOSCRIPT失败的用户
+// eval为 true时使用 EVAL，否则使用 EVALSHA
+func (in *Inbox) addBatch(ctx context.Context, uids []string, msg *InboxMessage, eval bool) (int, []string) {
+	pipe := client.Pipeline()
+	for _, uid := range uids {
+		keys := []string{in.inboxKey(uid), in.unreadKey(uid)}
+		args := []interface{}{msg.ID, msg.CreatedAt.UnixMilli(), in.opts.Cap, in.opts.TTL.Milliseconds()}
+		if eval {
+			inboxAddScript.Eval(ctx, pipe, keys, args...)
+		} else {
+			inboxAddScript.EvalSha(ctx, pipe, keys, args...)
+		}
+	}
+	cmds, err := pipe.Exec(ctx)
+	if err != nil && !redis.HasErrorPrefix(err, "NOSCRIPT") {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	sent := 0
+	var missing []string
+	for i, cmd := range cmds {
+		switch {
+		case cmd.Err() == nil:
+			sent++
+		case redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT"):
+			missing = append(missing, uids[i])
+		}
+	}
+	return sent, missing
+}
+
+// List 按时间倒序分页获取用户的消息及消息总数，page从1开始，size默认20，内容已过期的消息会被清理
+func (in *Inbox) List(ctx context.Context, uid string, page, size int64) ([]*InboxMessage, int64) {
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	start := (page - 1) * size
+	pipe := client.Pipeline()
+	total := pipe.ZCard(ctx, in.inboxKey(uid))
+	ids := pipe.ZRevRange(ctx, in.inboxKey(uid), start, start+size-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, 0
+	}
+	if len(ids.Val()) == 0 {
+		return nil, total.Val()
+	}
+
+	pipe = client.Pipeline()
+	bodies := make([]*redis.MapStringStringCmd, len(ids.Val()))
+	unread := make([]*redis.FloatCmd, len(ids.Val()))
+	for i, id := range ids.Val() {
+		bodies[i] = pipe.HGetAll(ctx, in.msgKey(id))
+		unread[i] = pipe.ZScore(ctx, in.unreadKey(uid), id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, 0
+	}
+	messages := make([]*InboxMessage, 0, len(ids.Val()))
+	var expired []interface{}
+	for i, id := range ids.Val() {
+		body := bodies[i].Val()
+		if len(body) == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		createdAt, _ := strconv.ParseInt(body["created_at"], 10, 64)
+		messages = append(messages, &InboxMessage{
+			ID:        id,
+			Kind:      body["kind"],
+			Title:     body["title"],
+			Content:   body["content"],
+			Extra:     body["extra"],
+			CreatedAt: time.UnixMilli(createdAt),
+			Read:      errors.Is(unread[i].Err(), redis.Nil),
+		})
+	}
+	if len(expired) > 0 {
+		in.remove(ctx, uid, expired)
+		total.SetVal(total.Val() - int64(len(expired)))
+	}
+	return messages, total.Val()
+}
+
+// UnreadCount 返回用户的未读消息数
+func (in *Inbox) UnreadCount(ctx context.Context, uid string) int64 {
+	n, err := client.ZCard(ctx, in.unreadKey(uid)).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return n
+}
+
+// MarkRead 将消息标记为已读，返回新标记的数量
+func (in *Inbox) MarkRead(ctx context.Context, uid string, ids ...string) int64 {
+	if len(ids) == 0 {
+		return 0
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	n, err := client.ZRem(ctx, in.unreadKey(uid), members...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return n
+}
+
+// MarkAllRead 将用户的全部消息标记为已读
+func (in *Inbox) MarkAllRead(ctx context.Context, uid string) bool {
+	return Del(ctx, in.unreadKey(uid))
+}
+
+// Delete 从用户收件箱中删除消息，消息内容由过期时间清理
+func (in *Inbox) Delete(ctx context.Context, uid string, ids ...string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	return in.remove(ctx, uid, members)
+}
+
+func (in *Inbox) remove(ctx context.Context, uid string, ids []interface{}) bool {
+	pipe := client.Pipeline()
+	pipe.ZRem(ctx, in.inboxKey(uid), ids...)
+	pipe.ZRem(ctx, in.unreadKey(uid), ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+func (in *Inbox) inboxKey(uid string) string {
+	return in.prefix + ":inbox:" + uid
+}
+
+func (in *Inbox) unreadKey(uid string) string {
+	return in.prefix + ":unread:" + uid
+}
+
+func (in *Inbox) msgKey(id string) string {
+	return in.prefix + ":msg:" + id
+}
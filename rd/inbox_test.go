@@ -0,0 +1,114 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestInbox(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	inbox := NewInbox("notice", InboxOptions{Cap: 3, TTL: time.Hour})
+	base := time.Now()
+
+	for i := 1; i <= 4; i++ {
+		msg := &InboxMessage{ID: fmt.Sprintf("m%d", i), Title: fmt.Sprintf("t%d", i), CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if !inbox.Send(ctx, "u1", msg) {
+			t.Fatalf("send m%d failed", i)
+		}
+	}
+	if n := inbox.UnreadCount(ctx, "u1"); n != 3 {
+		t.Fatalf("unread = %d, want capped to 3", n)
+	}
+
+	page, total := inbox.List(ctx, "u1", 1, 2)
+	if total != 3 || len(page) != 2 || page[0].ID != "m4" || page[1].ID != "m3" || page[0].Title != "t4" {
+		t.Fatalf("page 1 = %v total %d", page, total)
+	}
+	if page, _ = inbox.List(ctx, "u1", 2, 2); len(page) != 1 || page[0].ID != "m2" {
+		t.Fatalf("page 2 = %v, m1 should be capped", page)
+	}
+
+	if n := inbox.MarkRead(ctx, "u1", "m4", "m4", "missing"); n != 1 {
+		t.Fatalf("mark read = %d, want 1", n)
+	}
+	page, _ = inbox.List(ctx, "u1", 1, 3)
+	if !page[0].Read || page[1].Read || inbox.UnreadCount(ctx, "u1") != 2 {
+		t.Fatalf("read flags = %v %v", page[0].Read, page[1].Read)
+	}
+	inbox.MarkAllRead(ctx, "u1")
+	if inbox.UnreadCount(ctx, "u1") != 0 {
+		t.Fatal("all messages should be read")
+	}
+
+	users := make([]string, 1200)
+	for i := range users {
+		users[i] = fmt.Sprintf("b%d", i)
+	}
+	if sent := inbox.Broadcast(ctx, users, &InboxMessage{Title: "hello"}); sent != len(users) {
+		t.Fatalf("broadcast sent = %d, want %d", sent, len(users))
+	}
+	page, _ = inbox.List(ctx, "b1199", 1, 10)
+	if len(page) != 1 || page[0].Title != "hello" || inbox.UnreadCount(ctx, "b0") != 1 {
+		t.Fatalf("broadcast inbox = %v", page)
+	}
+
+	server.Del("notice:msg:" + page[0].ID)
+	if page, total = inbox.List(ctx, "b0", 1, 10); len(page) != 0 || total != 0 || inbox.UnreadCount(ctx, "b0") != 0 {
+		t.Fatalf("expired message should be cleaned, got %v total %d", page, total)
+	}
+}
+
+// scriptFlushHook 在 SCRIPT LOAD之后清空脚本缓存，模拟加载脚本后发生 SCRIPT FLUSH或主从切换
+type scriptFlushHook struct {
+	addr string
+}
+
+func (h *scriptFlushHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *scriptFlushHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if args := cmd.Args(); cmd.Name() == "script" && len(args) > 1 && args[1] == "load" {
+			c := redis.NewClient(&redis.Options{Addr: h.addr})
+			defer c.Close()
+			_ = c.ScriptFlush(ctx).Err()
+		}
+		return err
+	}
+}
+
+func (h *scriptFlushHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestInboxScriptFlushed(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	inbox := NewInbox("notice", InboxOptions{})
+	client.AddHook(&scriptFlushHook{addr: server.Addr()})
+
+	if sent := inbox.Broadcast(ctx, []string{"u1", "u2"}, &InboxMessage{Title: "hi"}); sent != 2 {
+		t.Fatalf("broadcast sent = %d, want the batch retried with EVAL", sent)
+	}
+	if inbox.UnreadCount(ctx, "u1") != 1 || inbox.UnreadCount(ctx, "u2") != 1 {
+		t.Fatal("both users should receive the message")
+	}
+}
+
+func TestInboxReadError(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	inbox := NewInbox("notice", InboxOptions{})
+	inbox.Send(ctx, "u1", &InboxMessage{ID: "m1", Title: "hi"})
+	// 未读集合类型错误时 ZSCORE出错，不应当作已读
+	server.Del(inbox.unreadKey("u1"))
+	_ = server.Set(inbox.unreadKey("u1"), "bad")
+	if page, _ := inbox.List(ctx, "u1", 1, 10); len(page) != 1 || page[0].Read {
+		t.Fatalf("page = %+v, a ZSCORE error should not mark the message as read", page)
+	}
+}
@@ -28,6 +28,8 @@ func InitRedisClient(addr, password string, db int, timeout time.Duration) {
 		if pong != "PONG" {
 			panic("redis init failed")
 		}
+		// 统计执行中的命令，Shutdown时等待其完成
+		client.AddHook(inflight)
 	})
 }
 
@@ -36,6 +38,12 @@ func GetRedisClient() *redis.Client {
 	return client
 }
 
+// SetRedisClient 替换redis客户端，用于测试时接入内存redis
+// 设置的客户端不会统计执行中的命令，Shutdown不会等待其命令完成
+func SetRedisClient(c *redis.Client) {
+	client = c
+}
+
 /*------------------------------------ 字符 操作 ------------------------------------*/
 
 // Set 设置 key的值
@@ -0,0 +1,183 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+)
+
+/*------------------------------------ 键空间通知 操作 ------------------------------------*/
+
+// KeyEvent 键空间事件类型
+type KeyEvent string
+
+const (
+	// KeyEventExpired key过期
+	KeyEventExpired KeyEvent = "expired"
+	// KeyEventEvicted key因内存淘汰被删除
+	KeyEventEvicted KeyEvent = "evicted"
+	// KeyEventSet 执行了 SET类命令
+	KeyEventSet KeyEvent = "set"
+	// KeyEventDel 执行了 DEL命令
+	KeyEventDel KeyEvent = "del"
+)
+
+// keyEventFlags 事件对应的 notify-keyspace-events 配置字符
+var keyEventFlags = map[KeyEvent]string{
+	KeyEventExpired: "x",
+	KeyEventEvicted: "e",
+	KeyEventSet:     "$",
+	KeyEventDel:     "g",
+}
+
+// KeyspaceEvent 键空间事件
+type KeyspaceEvent struct {
+	Event KeyEvent
+	Key   string
+	DB    int
+}
+
+// KeyspaceHandler 键空间事件处理函数
+type KeyspaceHandler func(ctx context.Context, e KeyspaceEvent)
+
+type keyspaceRoute struct {
+	event   KeyEvent
+	prefix  string
+	handler KeyspaceHandler
+}
+
+// KeyspaceListener 订阅 __keyevent@db__ 频道并按事件类型和 key前缀分发事件
+// redis的键空间通知不保证送达，断线期间的事件会丢失，需要可靠处理的场景应配合兜底扫描
+type KeyspaceListener struct {
+	db int
+
+	mu     sync.RWMutex
+	routes []keyspaceRoute
+}
+
+// NewKeyspaceListener 创建键空间事件监听，监听全局客户端所在的 db
+func NewKeyspaceListener() *KeyspaceListener {
+	return &KeyspaceListener{db: client.Options().DB}
+}
+
+// Handle 注册事件处理函数，仅处理 key以 prefix开头的事件，需在 Run之前调用
+func (l *KeyspaceListener) Handle(event KeyEvent, prefix string, handler KeyspaceHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes = append(l.routes, keyspaceRoute{event: event, prefix: prefix, handler: handler})
+}
+
+// EnsureNotifications 检查 notify-keyspace-events 配置，缺少已注册事件所需的配置时自动开启
+// 云厂商托管的 redis通常禁用了 CONFIG命令，此时返回错误，需在控制台手动开启
+func (l *KeyspaceListener) EnsureNotifications(ctx context.Context) error {
+	val, err := client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	current := val["notify-keyspace-events"]
+	want := mergeNotifyFlags(current, l.events())
+	if want == current {
+		return nil
+	}
+	if err = client.ConfigSet(ctx, "notify-keyspace-events", want).Err(); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	logs.CtxInfo(ctx, "notify-keyspace-events changed from %q to %q", current, want)
+	return nil
+}
+
+// Run 订阅已注册的事件并分发，断线后自动重连，阻塞直到 ctx结束
+func (l *KeyspaceListener) Run(ctx context.Context) error {
+	events := l.events()
+	if len(events) == 0 {
+		return nil
+	}
+	channels := make([]string, 0, len(events))
+	for _, event := range events {
+		channels = append(channels, fmt.Sprintf("__keyevent@%d__:%s", l.db, event))
+	}
+	pubsub := client.Subscribe(ctx, channels...)
+	defer pubsub.Close()
+
+	backoff := 100 * time.Millisecond
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// 连接断开后 ReceiveMessage会自动重连并重新订阅，这里只做退避
+			logs.CtxWarn(ctx, "keyspace listener receive failed: %s", err.Error())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, 5*time.Second)
+			continue
+		}
+		backoff = 100 * time.Millisecond
+		event := KeyEvent(msg.Channel[strings.LastIndex(msg.Channel, ":")+1:])
+		l.dispatch(ctx, KeyspaceEvent{Event: event, Key: msg.Payload, DB: l.db})
+	}
+}
+
+// dispatch 调用匹配的处理函数，处理函数 panic不会中断监听
+// 复制路由后再调用，处理函数中可以注册新的处理函数
+func (l *KeyspaceListener) dispatch(ctx context.Context, e KeyspaceEvent) {
+	l.mu.RLock()
+	routes := slices.Clone(l.routes)
+	l.mu.RUnlock()
+	for _, route := range routes {
+		if route.event != e.Event || !strings.HasPrefix(e.Key, route.prefix) {
+			continue
+		}
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					logs.CtxError(ctx, "keyspace handler panic, event: %s, key: %s, err: %v", e.Event, e.Key, p)
+				}
+			}()
+			route.handler(ctx, e)
+		}()
+	}
+}
+
+// mergeNotifyFlags 在当前配置上补充 E及 events所需的配置字符
+func mergeNotifyFlags(current string, events []KeyEvent) string {
+	want := current
+	if !strings.Contains(want, "E") {
+		want += "E"
+	}
+	for _, event := range events {
+		flag := keyEventFlags[event]
+		// A 表示除 m、n外的所有事件
+		if strings.Contains(want, flag) || strings.Contains(want, "A") {
+			continue
+		}
+		want += flag
+	}
+	return want
+}
+
+// events 返回已注册的事件类型
+func (l *KeyspaceListener) events() []KeyEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	seen := map[KeyEvent]bool{}
+	var events []KeyEvent
+	for _, route := range l.routes {
+		if !seen[route.event] {
+			seen[route.event] = true
+			events = append(events, route.event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,51 @@
+package rd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKeyspaceDispatch(t *testing.T) {
+	startRedis(t)
+	ctx := context.Background()
+	l := NewKeyspaceListener()
+	var got []string
+	l.Handle(KeyEventExpired, "order:", func(ctx context.Context, e KeyspaceEvent) {
+		got = append(got, "order "+e.Key)
+		// 处理函数中注册新的处理函数不应死锁
+		l.Handle(KeyEventDel, "order:", func(ctx context.Context, e KeyspaceEvent) { got = append(got, "del "+e.Key) })
+	})
+	l.Handle(KeyEventExpired, "", func(ctx context.Context, e KeyspaceEvent) { panic("boom") })
+	l.Handle(KeyEventExpired, "", func(ctx context.Context, e KeyspaceEvent) { got = append(got, "all "+e.Key) })
+
+	l.dispatch(ctx, KeyspaceEvent{Event: KeyEventExpired, Key: "order:1"})
+	l.dispatch(ctx, KeyspaceEvent{Event: KeyEventExpired, Key: "user:1"})
+	l.dispatch(ctx, KeyspaceEvent{Event: KeyEventDel, Key: "order:1"})
+	want := []string{"order order:1", "all order:1", "all user:1", "del order:1"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMergeNotifyFlags(t *testing.T) {
+	cases := []struct {
+		current string
+		events  []KeyEvent
+		want    string
+	}{
+		{"", []KeyEvent{KeyEventExpired}, "Ex"},
+		{"Ex", []KeyEvent{KeyEventExpired, KeyEventDel}, "Exg"},
+		{"KEA", []KeyEvent{KeyEventExpired, KeyEventSet}, "KEA"},
+		{"Kx", []KeyEvent{KeyEventEvicted}, "KxEe"},
+	}
+	for _, c := range cases {
+		if got := mergeNotifyFlags(c.current, c.events); got != c.want {
+			t.Fatalf("merge(%q, %v) = %q, want %q", c.current, c.events, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package rd
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 选主 操作 ------------------------------------*/
+
+// leaderCampaignScript 竞选 leader，成功时返回递增的 fencing token，失败返回0
+// key仍是自己的ID时（续期出错后本地已退位）直接收回，开始新的任期
+// KEYS[1] leader key，KEYS[2] fencing token key，ARGV[1] 候选者ID，ARGV[2] 租约毫秒数
+var leaderCampaignScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur and cur ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return redis.call('INCR', KEYS[2])
+`)
+
+// leaderRenewScript 仍是 leader时续期租约
+var leaderRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// leaderResignScript 仍是 leader时主动退位
+var leaderResignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// LeaderOptions 选主配置
+type LeaderOptions struct {
+	// 租约时长，leader崩溃后最多经过一个租约其他实例即可接任，默认10秒
+	Lease time.Duration
+	// 续期及竞选的间隔，默认为租约的三分之一
+	RenewInterval time.Duration
+	// 成为 leader时回调，ctx在失去 leader身份时取消，fence为本次任期的 fencing token
+	OnElected func(ctx context.Context, fence int64)
+	// 失去 leader身份时回调
+	OnRevoked func()
+}
+
+// LeaderElection 基于 redis租约的选主，保证同一时刻只有一个实例执行单例任务
+type LeaderElection struct {
+	key  string
+	id   string
+	opts LeaderOptions
+
+	mu    sync.RWMutex
+	fence int64
+	// expires 本地计算的租约到期时间，续期出错时在此之前仍保持 leader身份
+	expires time.Time
+	cancel  context.CancelFunc
+}
+
+// NewLeaderElection 创建选主组件，同一 key下的所有实例竞争同一个 leader
+func NewLeaderElection(key string, opts LeaderOptions) *LeaderElection {
+	if opts.Lease <= 0 {
+		opts.Lease = 10 * time.Second
+	}
+	if opts.RenewInterval <= 0 {
+		opts.RenewInterval = opts.Lease / 3
+	}
+	host, _ := os.Hostname()
+	return &LeaderElection{
+		key:  key,
+		id:   host + ":" + newToken(),
+		opts: opts,
+	}
+}
+
+// Run 持续竞选和续期，阻塞直到 ctx结束，结束时若为 leader则主动退位
+func (e *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.opts.RenewInterval)
+	defer ticker.Stop()
+	for {
+		e.tick(ctx)
+		select {
+		case <-ctx.Done():
+			e.Resign(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick 是 leader时续期，否则竞选
+// 续期出错时保持 leader身份直到本地租约到期，确认 key已被他人持有时立即退位
+func (e *LeaderElection) tick(ctx context.Context) {
+	if e.term() == 0 {
+		e.campaign(ctx)
+		return
+	}
+	start := time.Now()
+	val, err := leaderRenewScript.Run(ctx, client, []string{e.key}, e.id, e.opts.Lease.Milliseconds()).Int64()
+	switch {
+	case err != nil && !e.IsLeader():
+		logs.CtxWarn(ctx, "leader renew failed and lease expired, key: %s, err: %s", e.key, err.Error())
+		e.revoke(ctx)
+	case err != nil:
+		logs.CtxWarn(ctx, "leader renew failed, key: %s, err: %s", e.key, err.Error())
+	case val == 1:
+		e.mu.Lock()
+		e.expires = start.Add(e.opts.Lease)
+		e.mu.Unlock()
+	default:
+		e.revoke(ctx)
+	}
+}
+
+// IsLeader 判断当前实例是否为 leader，本地租约到期后即使尚未续期成功也返回 false
+func (e *LeaderElection) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.fence > 0 && time.Now().Before(e.expires)
+}
+
+// Fence 返回当前任期的 fencing token，不是 leader时返回0
+// 下游写入时携带该值并拒绝比已见过的值更小的 token，可避免旧 leader的延迟写入
+func (e *LeaderElection) Fence() int64 {
+	if !e.IsLeader() {
+		return 0
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.fence
+}
+
+// term 返回尚未退位的任期 fencing token，本地租约是否到期不影响
+func (e *LeaderElection) term() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.fence
+}
+
+// Resign 主动退位，其他实例可立即接任
+func (e *LeaderElection) Resign(ctx context.Context) {
+	if e.term() == 0 {
+		return
+	}
+	if err := leaderResignScript.Run(ctx, client, []string{e.key}, e.id).Err(); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	e.revoke(ctx)
+}
+
+// Wrap 包装定时任务，仅在当前实例为 leader时执行
+func (e *LeaderElection) Wrap(job func()) func() {
+	return func() {
+		if e.IsLeader() {
+			job()
+		}
+	}
+}
+
+func (e *LeaderElection) campaign(ctx context.Context) {
+	start := time.Now()
+	fence, err := leaderCampaignScript.Run(ctx, client, []string{e.key, e.key + ":fence"}, e.id, e.opts.Lease.Milliseconds()).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return
+	}
+	if fence == 0 {
+		return
+	}
+	leaderCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	e.mu.Lock()
+	e.fence = fence
+	e.expires = start.Add(e.opts.Lease)
+	e.cancel = cancel
+	e.mu.Unlock()
+	logs.CtxInfo(ctx, "leader elected, key: %s, id: %s, fence: %d", e.key, e.id, fence)
+	if e.opts.OnElected != nil {
+		go e.opts.OnElected(leaderCtx, fence)
+	}
+}
+
+func (e *LeaderElection) revoke(ctx context.Context) {
+	e.mu.Lock()
+	if e.fence == 0 {
+		e.mu.Unlock()
+		return
+	}
+	e.fence = 0
+	cancel := e.cancel
+	e.cancel = nil
+	e.mu.Unlock()
+	cancel()
+	logs.CtxInfo(ctx, "leader revoked, key: %s, id: %s", e.key, e.id)
+	if e.opts.OnRevoked != nil {
+		e.opts.OnRevoked()
+	}
+}
@@ -0,0 +1,69 @@
+package rd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	var revoked int
+	a := NewLeaderElection("leader", LeaderOptions{Lease: time.Minute, OnRevoked: func() { revoked++ }})
+	b := NewLeaderElection("leader", LeaderOptions{Lease: time.Minute})
+
+	a.tick(ctx)
+	b.tick(ctx)
+	if !a.IsLeader() || a.Fence() != 1 || b.IsLeader() || b.Fence() != 0 {
+		t.Fatalf("a = %v/%d, b = %v/%d, want a elected with fence 1", a.IsLeader(), a.Fence(), b.IsLeader(), b.Fence())
+	}
+	a.tick(ctx)
+	if !a.IsLeader() || server.TTL("leader") != time.Minute {
+		t.Fatalf("renew ttl = %s, want the full lease", server.TTL("leader"))
+	}
+
+	// 续期出错时保持 leader直到本地租约到期
+	server.Close()
+	a.tick(ctx)
+	if !a.IsLeader() || revoked != 0 {
+		t.Fatal("a transient renew error should not revoke leadership")
+	}
+	a.mu.Lock()
+	a.expires = time.Now()
+	a.mu.Unlock()
+	if a.IsLeader() || a.Fence() != 0 {
+		t.Fatal("leadership should end when the local lease expires")
+	}
+	a.tick(ctx)
+	if revoked != 1 {
+		t.Fatalf("revoked = %d, want 1 after the lease expired", revoked)
+	}
+
+	// key仍是自己的ID时重新竞选可收回
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	a.tick(ctx)
+	if !a.IsLeader() || a.Fence() != 2 {
+		t.Fatalf("reclaim = %v/%d, want a new term with fence 2", a.IsLeader(), a.Fence())
+	}
+
+	// key被他人持有时立即退位
+	_ = server.Set("leader", "other")
+	a.tick(ctx)
+	if a.IsLeader() || revoked != 2 {
+		t.Fatalf("a = %v, revoked %d, want revoked after losing the key", a.IsLeader(), revoked)
+	}
+
+	server.Del("leader")
+	b.tick(ctx)
+	b.Resign(ctx)
+	if b.IsLeader() || server.Exists("leader") {
+		t.Fatal("resign should release the key")
+	}
+	a.tick(ctx)
+	if !a.IsLeader() || a.Fence() != 4 {
+		t.Fatalf("a after resign = %v/%d, want elected with fence 4", a.IsLeader(), a.Fence())
+	}
+}
@@ -0,0 +1,248 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+/*------------------------------------ 事务发件箱 操作 ------------------------------------*/
+
+// 发件箱消息状态
+const (
+	OutboxPending int8 = iota
+	OutboxSent
+	OutboxFailed
+)
+
+// OutboxMessage 发件箱消息，与业务数据在同一个数据库事务中写入
+type OutboxMessage struct {
+	ID            uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Topic         string     `gorm:"size:128;not null" json:"topic"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`
+	Status        int8       `gorm:"not null;default:0;index:idx_outbox_pending,priority:1" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_pending,priority:2" json:"next_attempt_at"`
+	LastError     string     `gorm:"size:512" json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// TableName 发件箱表名
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+// SaveOutbox 在业务事务 tx中写入发件箱消息，payload为字符串或 []byte时原样保存，其他类型序列化为 json
+func SaveOutbox(tx *gorm.DB, topic string, payload any) error {
+	var data string
+	switch v := payload.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	return tx.Create(&OutboxMessage{Topic: topic, Payload: data, NextAttemptAt: time.Now()}).Error
+}
+
+// OutboxOptions 发件箱投递配置
+type OutboxOptions struct {
+	// 每批投递的消息数，默认100
+	BatchSize int
+	// 轮询间隔，默认1秒
+	Interval time.Duration
+	// 最大投递次数，超过后标记为失败，默认10
+	MaxAttempts int
+	// 消息重试及数据库、redis不可用时的退避初始时长，按2的指数增长，最长5分钟，默认1秒
+	Backoff time.Duration
+	// 为 true时 RPUSH到 list，否则 XADD到 stream
+	UseList bool
+	// stream的近似最大长度，0表示不限制
+	StreamMaxLen int64
+	// 选主 key，多实例中只有 leader执行投递，默认 outbox:relay
+	LeaderKey string
+}
+
+// OutboxRelay 将发件箱中待投递的消息发布到 redis stream或 list，至少投递一次，消费方需按消息ID去重
+type OutboxRelay struct {
+	db     *gorm.DB
+	opts   OutboxOptions
+	leader *LeaderElection
+}
+
+// NewOutboxRelay 创建发件箱投递
+func NewOutboxRelay(db *gorm.DB, opts OutboxOptions) *OutboxRelay {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 10
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.LeaderKey == "" {
+		opts.LeaderKey = "outbox:relay"
+	}
+	return &OutboxRelay{
+		db:     db,
+		opts:   opts,
+		leader: NewLeaderElection(opts.LeaderKey, LeaderOptions{}),
+	}
+}
+
+// Run 参与选主并在成为 leader后持续投递，阻塞直到 ctx结束
+// 数据库或 redis不可用时按 Backoff指数退避后再投递
+func (r *OutboxRelay) Run(ctx context.Context) {
+	go r.leader.Run(ctx)
+	failures := 0
+	for {
+		wait := r.opts.Interval
+		if failures > 0 {
+			wait = r.backoff(failures)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if !r.leader.IsLeader() {
+			continue
+		}
+		// 一批全部投递成功时立即投递下一批
+		for ctx.Err() == nil {
+			sent, err := r.RelayOnce(ctx)
+			if err != nil {
+				failures++
+				break
+			}
+			failures = 0
+			if sent < r.opts.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// RelayOnce 投递一批到期的待投递消息，返回成功发布并标记为已投递的消息数
+// 数据库或 redis不可用时返回错误，未发布的消息保持待投递且不计入投递次数
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	var messages []OutboxMessage
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", OutboxPending, time.Now()).
+		Order("id").Limit(r.opts.BatchSize).Find(&messages).Error
+	if err != nil {
+		logs.CtxWarn(ctx, "outbox query failed: %s", err.Error())
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]redis.Cmder, len(messages))
+	for i, m := range messages {
+		id := strconv.FormatUint(m.ID, 10)
+		if r.opts.UseList {
+			data, _ := json.Marshal(map[string]string{"id": id, "payload": m.Payload})
+			cmds[i] = pipe.RPush(ctx, m.Topic, data)
+			continue
+		}
+		cmds[i] = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: m.Topic,
+			MaxLen: r.opts.StreamMaxLen,
+			Approx: r.opts.StreamMaxLen > 0,
+			Values: map[string]interface{}{"id": id, "payload": m.Payload},
+		})
+	}
+	_, execErr := pipe.Exec(ctx)
+	if isRedisReply(execErr) {
+		execErr = nil
+	}
+
+	var sent []uint64
+	var unavailable error
+	now := time.Now()
+	for i, m := range messages {
+		err := cmds[i].Err()
+		if err == nil && execErr != nil {
+			// 连接失败时未执行的命令没有错误，视为未发布
+			err = execErr
+		}
+		if err == nil {
+			sent = append(sent, m.ID)
+			continue
+		}
+		if !isRedisReply(err) {
+			// 连接失败、超时、熔断等不是消息本身的问题，不计入投递次数
+			unavailable = err
+			continue
+		}
+		r.retry(ctx, &m, err)
+	}
+	if len(sent) > 0 {
+		err = r.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id IN ?", sent).
+			Updates(map[string]interface{}{"status": OutboxSent, "sent_at": now}).Error
+		if err != nil {
+			// 已发布但未标记的消息会被再次投递
+			logs.CtxWarn(ctx, "outbox mark sent failed: %s", err.Error())
+			return 0, err
+		}
+	}
+	if unavailable != nil {
+		logs.CtxWarn(ctx, "outbox publish failed: %s", unavailable.Error())
+		return len(sent), unavailable
+	}
+	return len(sent), nil
+}
+
+// retry 记录投递失败并按指数退避安排下次投递，超过最大次数后标记为失败
+func (r *OutboxRelay) retry(ctx context.Context, m *OutboxMessage, cause error) {
+	attempts := m.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":   attempts,
+		"last_error": truncate(cause.Error(), 512),
+	}
+	if attempts >= r.opts.MaxAttempts {
+		updates["status"] = OutboxFailed
+		logs.CtxError(ctx, "outbox message %d to %s failed after %d attempts: %s", m.ID, m.Topic, attempts, cause.Error())
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(r.backoff(attempts))
+	}
+	if err := r.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ?", m.ID).Updates(updates).Error; err != nil {
+		logs.CtxWarn(ctx, "outbox mark retry failed: %s", err.Error())
+	}
+}
+
+// isRedisReply 判断错误是否为 redis返回的错误，如 WRONGTYPE
+func isRedisReply(err error) bool {
+	var rerr redis.Error
+	return errors.As(err, &rerr)
+}
+
+// backoff 第 n次失败后的退避时长，按2的指数增长，最长5分钟
+func (r *OutboxRelay) backoff(n int) time.Duration {
+	return min(r.opts.Backoff<<min(n-1, 20), 5*time.Minute)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
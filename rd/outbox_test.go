@@ -0,0 +1,148 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openOutboxDB 创建测试用的 sqlite数据库并建好发件箱表
+func openOutboxDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "outbox.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&OutboxMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestOutboxRelayBatches(t *testing.T) {
+	startRedis(t)
+	db := openOutboxDB(t)
+	ctx := context.Background()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < 4; i++ {
+			if err := SaveOutbox(tx, "orders", map[string]int{"order": i}); err != nil {
+				return err
+			}
+		}
+		return SaveOutbox(tx, "orders", "raw")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay := NewOutboxRelay(db, OutboxOptions{BatchSize: 2})
+	for i, want := range []int{2, 2, 1, 0} {
+		if n, err := relay.RelayOnce(ctx); err != nil || n != want {
+			t.Fatalf("batch %d relayed %d %v, want %d", i, n, err, want)
+		}
+	}
+	msgs := client.XRange(ctx, "orders", "-", "+").Val()
+	if len(msgs) != 5 || msgs[0].Values["id"] != "1" || msgs[0].Values["payload"] != `{"order":0}` || msgs[4].Values["payload"] != "raw" {
+		t.Fatalf("stream = %v", msgs)
+	}
+	var rows []OutboxMessage
+	db.Order("id").Find(&rows)
+	for _, row := range rows {
+		if row.Status != OutboxSent || row.SentAt == nil {
+			t.Fatalf("row %d status = %d sent at %v, want sent", row.ID, row.Status, row.SentAt)
+		}
+	}
+}
+
+func TestOutboxRelayList(t *testing.T) {
+	startRedis(t)
+	db := openOutboxDB(t)
+	ctx := context.Background()
+	if err := SaveOutbox(db, "jobs", []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := NewOutboxRelay(db, OutboxOptions{UseList: true}).RelayOnce(ctx); n != 1 {
+		t.Fatalf("relayed %d, want 1", n)
+	}
+	_, data := LIndex(ctx, "jobs", 0)
+	var item map[string]string
+	if err := json.Unmarshal([]byte(data), &item); err != nil || item["id"] != "1" || item["payload"] != "payload" {
+		t.Fatalf("list item = %v %v", item, err)
+	}
+}
+
+func TestOutboxRelayRetry(t *testing.T) {
+	startRedis(t)
+	db := openOutboxDB(t)
+	ctx := context.Background()
+	// topic已存在其他类型的 key，XADD会失败
+	Set(ctx, "broken", "string")
+	if err := SaveOutbox(db, "broken", "m"); err != nil {
+		t.Fatal(err)
+	}
+	relay := NewOutboxRelay(db, OutboxOptions{MaxAttempts: 3, Backoff: time.Minute})
+	var row OutboxMessage
+	// 退避时长按2的指数增长
+	for attempt, backoff := range []time.Duration{time.Minute, 2 * time.Minute} {
+		relay.RelayOnce(ctx)
+		db.First(&row)
+		if row.Status != OutboxPending || row.Attempts != attempt+1 || !strings.Contains(row.LastError, "WRONGTYPE") {
+			t.Fatalf("after failure %d = %+v", attempt+1, row)
+		}
+		if delay := time.Until(row.NextAttemptAt); delay < backoff-time.Second || delay > backoff {
+			t.Fatalf("next attempt in %s, want %s backoff", delay, backoff)
+		}
+		if n, _ := relay.RelayOnce(ctx); n != 0 {
+			t.Fatalf("relayed %d before backoff elapsed, want 0", n)
+		}
+		db.Model(&row).Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+
+	relay.RelayOnce(ctx)
+	db.First(&row)
+	if row.Status != OutboxFailed || row.Attempts != 3 {
+		t.Fatalf("after max attempts = %+v, want failed", row)
+	}
+	if n, _ := relay.RelayOnce(ctx); n != 0 {
+		t.Fatalf("relayed %d failed messages, want 0", n)
+	}
+}
+
+func TestOutboxRelayUnavailable(t *testing.T) {
+	server := startRedis(t)
+	db := openOutboxDB(t)
+	ctx := context.Background()
+	if err := SaveOutbox(db, "orders", "m"); err != nil {
+		t.Fatal(err)
+	}
+	relay := NewOutboxRelay(db, OutboxOptions{MaxAttempts: 1})
+
+	server.Close()
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("relay with redis down = %d %v, want an error", n, err)
+	}
+	var row OutboxMessage
+	db.First(&row)
+	if row.Status != OutboxPending || row.Attempts != 0 {
+		t.Fatalf("after outage = %+v, should not use up attempts", row)
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	// 标记已投递失败时不能当作成功，否则 Run会立即重复投递同一批
+	db.Callback().Update().Before("gorm:update").Register("fail", func(tx *gorm.DB) {
+		_ = tx.AddError(errors.New("db down"))
+	})
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 0 {
+		t.Fatalf("relay with mark failure = %d %v, want an error", n, err)
+	}
+}
@@ -0,0 +1,27 @@
+// Package rdtest 提供基于 miniredis的内存redis，用于在没有真实redis的环境中对依赖 rd的代码进行单元测试
+// miniredis支持字符串、hash、list、set、有序集合、stream、Pub/Sub及 Lua脚本，
+// 但不支持 BITFIELD、GEOSEARCH等少数命令，涉及这些命令的代码仍需连接真实redis测试
+package rdtest
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/oho-panda/utils/v2/rd"
+	"github.com/redis/go-redis/v9"
+)
+
+// Start 启动内存redis并接入 rd，测试结束后自动关闭并恢复原来的客户端
+// 返回的 miniredis可用于检查数据或通过 FastForward模拟过期
+func Start(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	old := rd.GetRedisClient()
+	rd.SetRedisClient(c)
+	t.Cleanup(func() {
+		rd.SetRedisClient(old)
+		_ = c.Close()
+	})
+	return server
+}
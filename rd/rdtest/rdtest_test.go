@@ -0,0 +1,25 @@
+package rdtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/oho-panda/utils/v2/rd"
+	"github.com/oho-panda/utils/v2/rd/rdtest"
+)
+
+func TestStart(t *testing.T) {
+	server := rdtest.Start(t)
+	ctx := context.Background()
+	if !rd.SetEX(ctx, "k", "v", time.Second) {
+		t.Fatal("set failed")
+	}
+	if got, _ := server.Get("k"); got != "v" {
+		t.Fatalf("server value = %q, want v", got)
+	}
+	server.FastForward(2 * time.Second)
+	if ok, _ := rd.Get(ctx, "k"); ok {
+		t.Fatal("key should be expired")
+	}
+}
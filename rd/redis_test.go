@@ -2,21 +2,39 @@ package rd
 
 import (
 	"context"
-	"fmt"
 	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
+// startRedis 启动内存redis并接入全局客户端
+func startRedis(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	old := client
+	client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+		client = old
+	})
+	return server
+}
+
 func TestRedis(t *testing.T) {
+	startRedis(t)
 	ctx := context.Background()
-	//InitRedisClient("redis host", "redis password", 6, time.Second*10)
-	set := Set(ctx, "test", "111")
-	if set {
-		fmt.Println("redis test success")
+	if !Set(ctx, "test", "111") {
+		t.Fatal("set failed")
 	}
 	ok, val := Get(ctx, "test")
-	fmt.Println(ok, val)
-	fmt.Println(Del(ctx, "test"))
+	if !ok || val != "111" {
+		t.Fatalf("get = %v %q, want true 111", ok, val)
+	}
+	if !Del(ctx, "test") {
+		t.Fatal("del failed")
+	}
 	ok, val = Get(ctx, "test")
-	fmt.Println(ok, val)
-
+	if ok || val != "" {
+		t.Fatalf("get after del = %v %q, want false", ok, val)
+	}
 }
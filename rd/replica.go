@@ -0,0 +1,198 @@
+package rd
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oho-panda/utils/v2/consts"
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 读写分离 操作 ------------------------------------*/
+
+// ReplicaStrategy 从节点选择策略
+type ReplicaStrategy int
+
+const (
+	// ReplicaRoundRobin 轮询
+	ReplicaRoundRobin ReplicaStrategy = iota
+	// ReplicaLatency 选择最近一次探测延迟最低的从节点
+	ReplicaLatency
+)
+
+type replica struct {
+	client  *redis.Client
+	healthy atomic.Bool
+	latency atomic.Int64
+}
+
+// ReplicaRouter 将只读命令路由到从节点，从节点全部不可用时回退到主节点
+type ReplicaRouter struct {
+	strategy ReplicaStrategy
+	replicas []*replica
+	next     atomic.Uint64
+	cancel   context.CancelFunc
+	// primary 安装了该 hook的全局客户端
+	primary *redis.Client
+	// tenant 在该 hook之后安装的租户隔离，发往从节点的租户命令需先经过租户隔离
+	tenant atomic.Pointer[TenantIsolation]
+}
+
+var (
+	replicaMu sync.Mutex
+	// replicaRouters 通过 EnableReplicas安装的读写分离，租户隔离、链路追踪和 Shutdown需要作用到其从节点
+	replicaRouters []*ReplicaRouter
+)
+
+// globalReplicas 返回安装在当前全局客户端上的读写分离
+func globalReplicas() []*ReplicaRouter {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+	var routers []*ReplicaRouter
+	for _, r := range replicaRouters {
+		if r.primary == client {
+			routers = append(routers, r)
+		}
+	}
+	return routers
+}
+
+// EnableReplicas 为全局 redis客户端启用读写分离，需在 InitRedisClient之后调用
+// Get、HGetAll、LRange、SMembers、ZRevRangeWithScores等只读命令会发往从节点，写命令和 pipeline仍发往主节点
+// 需要读到刚写入的数据时，使用 WithPrimary包装 ctx强制读主节点
+func EnableReplicas(addrs []string, password string, timeout time.Duration, strategy ReplicaStrategy) *ReplicaRouter {
+	r := &ReplicaRouter{strategy: strategy, primary: client}
+	for _, addr := range addrs {
+		rp := &replica{client: redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     password,
+			DB:           client.Options().DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			PoolSize:     100,
+			MinIdleConns: 10,
+			// 从节点失败时直接回退主节点，不在从节点上重试
+			MaxRetries: -1,
+		})}
+		rp.healthy.Store(true)
+		r.replicas = append(r.replicas, rp)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.healthCheck(ctx)
+	client.AddHook(r)
+	replicaMu.Lock()
+	replicaRouters = append(replicaRouters, r)
+	replicaMu.Unlock()
+	return r
+}
+
+// WithPrimary 返回强制读主节点的 ctx，用于写后立即读的场景
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consts.PrimaryKey, true)
+}
+
+// Close 停止健康检查并关闭从节点连接，关闭后只读命令回到主节点
+func (r *ReplicaRouter) Close() {
+	replicaMu.Lock()
+	replicaRouters = slices.DeleteFunc(replicaRouters, func(rr *ReplicaRouter) bool { return rr == r })
+	replicaMu.Unlock()
+	r.cancel()
+	for _, rp := range r.replicas {
+		rp.healthy.Store(false)
+		_ = rp.client.Close()
+	}
+}
+
+func (r *ReplicaRouter) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (r *ReplicaRouter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if primary, _ := ctx.Value(consts.PrimaryKey).(bool); primary || !isReadCommand(cmd) {
+			return next(ctx, cmd)
+		}
+		rp := r.pick()
+		if rp == nil {
+			return next(ctx, cmd)
+		}
+		process := rp.client.Process
+		if t := r.tenant.Load(); t != nil {
+			// 租户隔离的 hook在之后才执行，发往从节点前先经过租户隔离加上前缀
+			process = t.ProcessHook(process)
+		}
+		err := process(ctx, cmd)
+		if !isBreakerFailure(err) {
+			return err
+		}
+		// 从节点不可用，标记后回退到主节点
+		rp.healthy.Store(false)
+		logs.CtxWarn(ctx, "redis replica %s failed, fallback to primary: %s", rp.client.Options().Addr, err.Error())
+		cmd.SetErr(nil)
+		return next(ctx, cmd)
+	}
+}
+
+func (r *ReplicaRouter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// pick 按策略选择健康的从节点，没有健康的从节点时返回 nil
+func (r *ReplicaRouter) pick() *replica {
+	var best *replica
+	switch r.strategy {
+	case ReplicaLatency:
+		for _, rp := range r.replicas {
+			if rp.healthy.Load() && (best == nil || rp.latency.Load() < best.latency.Load()) {
+				best = rp
+			}
+		}
+	default:
+		n := uint64(len(r.replicas))
+		start := r.next.Add(1)
+		for i := uint64(0); i < n; i++ {
+			if rp := r.replicas[(start+i)%n]; rp.healthy.Load() {
+				return rp
+			}
+		}
+	}
+	return best
+}
+
+// healthCheck 每秒探测一次从节点的可用性和延迟
+func (r *ReplicaRouter) healthCheck(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var wg sync.WaitGroup
+		for _, rp := range r.replicas {
+			wg.Add(1)
+			go func(rp *replica) {
+				defer wg.Done()
+				start := time.Now()
+				err := rp.client.Ping(ctx).Err()
+				healthy := err == nil
+				if healthy {
+					rp.latency.Store(int64(time.Since(start)))
+				}
+				if rp.healthy.Swap(healthy) != healthy {
+					logs.CtxWarn(ctx, "redis replica %s healthy changed to %v", rp.client.Options().Addr, healthy)
+				}
+			}(rp)
+		}
+		wg.Wait()
+	}
+}
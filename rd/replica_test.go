@@ -0,0 +1,35 @@
+package rd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	primary := startRedis(t)
+	replica := miniredis.RunT(t)
+	router := EnableReplicas([]string{replica.Addr()}, "", time.Second, ReplicaRoundRobin)
+	defer router.Close()
+	ctx := context.Background()
+
+	_ = primary.Set("k", "primary")
+	_ = replica.Set("k", "replica")
+	if _, val := Get(ctx, "k"); val != "replica" {
+		t.Fatalf("read = %q, want replica", val)
+	}
+	if _, val := Get(WithPrimary(ctx), "k"); val != "primary" {
+		t.Fatalf("read with primary = %q, want primary", val)
+	}
+	Set(ctx, "w", "1")
+	if !primary.Exists("w") || replica.Exists("w") {
+		t.Fatal("write should go to primary only")
+	}
+
+	replica.Close()
+	if _, val := Get(ctx, "k"); val != "primary" {
+		t.Fatalf("read after replica down = %q, want primary fallback", val)
+	}
+}
@@ -0,0 +1,182 @@
+package rd
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 分片 操作 ------------------------------------*/
+
+var ring *redis.Ring
+var ringOnce sync.Once
+
+// InitRedisRing 初始化多个独立redis节点组成的分片客户端，适用于纯缓存场景
+// addrs为节点名到地址的映射，节点名参与哈希计算，更换地址时保持节点名不变可避免数据迁移
+// 每个节点每500毫秒检测一次健康状态，连续失败的节点会被移出哈希环，key重新分配到存活节点，恢复后自动加回
+// ketama为 true时使用 ketama一致性哈希，增减节点时只迁移相邻区间的 key，否则使用 rendezvous哈希
+// 节点上下线通过 go-redis内部日志输出，需要接入 logs时调用 UseRedisLogger
+func InitRedisRing(addrs map[string]string, password string, db int, timeout time.Duration, ketama bool) {
+	ringOnce.Do(func() {
+		opt := &redis.RingOptions{
+			Addrs:        addrs,
+			Password:     password,
+			DB:           db,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			PoolSize:     100,
+			MinIdleConns: 10,
+			MaxRetries:   3,
+		}
+		if ketama {
+			opt.NewConsistentHash = NewKetama
+		}
+		ring = redis.NewRing(opt)
+		if err := ring.Ping(context.Background()).Err(); err != nil {
+			panic("redis ring init failed: " + err.Error())
+		}
+	})
+}
+
+// GetRedisRing 获取分片客户端
+func GetRedisRing() *redis.Ring {
+	return ring
+}
+
+// RingLiveShards 返回当前存活的节点数
+func RingLiveShards() int {
+	return ring.Len()
+}
+
+// RingGet 获取 key的值
+func RingGet(ctx context.Context, key string) (bool, string) {
+	result, err := ring.Get(ctx, key).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false, ""
+	}
+	return true, result
+}
+
+// RingSetEX 设置 key的值并指定过期时间，ex为0时不过期
+func RingSetEX(ctx context.Context, key, value string, ex time.Duration) bool {
+	result, err := ring.Set(ctx, key, value, ex).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return result == "OK"
+}
+
+// RingMGet 批量获取多个 key的值，按节点分组并行执行，返回存在的 key及其值
+func RingMGet(ctx context.Context, keys ...string) map[string]string {
+	cmds := make([]*redis.StringCmd, len(keys))
+	// Ring的 pipeline会按 key所在节点拆分命令并并行发送
+	_, err := ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	result := make(map[string]string, len(keys))
+	for i, cmd := range cmds {
+		if val, err := cmd.Result(); err == nil {
+			result[keys[i]] = val
+		}
+	}
+	return result
+}
+
+// RingMSetEX 批量设置多个 key的值并指定过期时间，按节点分组并行执行
+func RingMSetEX(ctx context.Context, data map[string]string, ex time.Duration) bool {
+	_, err := ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range data {
+			pipe.Set(ctx, key, value, ex)
+		}
+		return nil
+	})
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// RingDel 删除多个 key，按节点分组并行执行，并返回删除的数量
+func RingDel(ctx context.Context, keys ...string) int64 {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted
+}
+
+// ketama ketama一致性哈希，每个节点在环上有160个虚拟节点
+type ketama struct {
+	points []uint32
+	shards map[uint32]string
+}
+
+// NewKetama 创建 ketama一致性哈希，可用于 redis.RingOptions.NewConsistentHash
+func NewKetama(shards []string) redis.ConsistentHash {
+	k := &ketama{shards: make(map[uint32]string, len(shards)*160)}
+	for _, shard := range shards {
+		for i := 0; i < 40; i++ {
+			digest := md5.Sum([]byte(shard + "-" + strconv.Itoa(i)))
+			// 每个 md5摘要生成4个虚拟节点
+			for j := 0; j < 4; j++ {
+				point := binary.LittleEndian.Uint32(digest[j*4:])
+				k.shards[point] = shard
+				k.points = append(k.points, point)
+			}
+		}
+	}
+	sort.Slice(k.points, func(i, j int) bool { return k.points[i] < k.points[j] })
+	return k
+}
+
+func (k *ketama) Get(key string) string {
+	if len(k.points) == 0 {
+		return ""
+	}
+	digest := md5.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(k.points), func(i int) bool { return k.points[i] >= hash })
+	if i == len(k.points) {
+		i = 0
+	}
+	return k.shards[k.points[i]]
+}
+
+// UseRedisLogger 将 go-redis的内部日志输出到 logs
+// go-redis的日志是进程级的，调用后对所有 redis客户端生效
+func UseRedisLogger() {
+	redis.SetLogger(redisLogger{})
+}
+
+// redisLogger 将 go-redis的内部日志输出到 logs
+type redisLogger struct{}
+
+func (redisLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	logs.CtxWarn(ctx, format, v...)
+}
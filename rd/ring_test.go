@@ -0,0 +1,64 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRing(t *testing.T) {
+	s1, s2 := miniredis.RunT(t), miniredis.RunT(t)
+	old := ring
+	ring = redis.NewRing(&redis.RingOptions{
+		Addrs:             map[string]string{"s1": s1.Addr(), "s2": s2.Addr()},
+		NewConsistentHash: NewKetama,
+	})
+	t.Cleanup(func() {
+		_ = ring.Close()
+		ring = old
+	})
+	ctx := context.Background()
+
+	data := map[string]string{}
+	keys := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("ring:%d", i)
+		data[key] = fmt.Sprint(i)
+		keys = append(keys, key)
+	}
+	if !RingMSetEX(ctx, data, 0) {
+		t.Fatal("mset failed")
+	}
+	n1, n2 := len(s1.Keys()), len(s2.Keys())
+	if n1+n2 != 100 || n1 == 0 || n2 == 0 {
+		t.Fatalf("keys distributed %d/%d, want both shards used", n1, n2)
+	}
+	got := RingMGet(ctx, append(keys, "ring:missing")...)
+	if len(got) != 100 || got["ring:42"] != "42" {
+		t.Fatalf("mget returned %d keys", len(got))
+	}
+	if deleted := RingDel(ctx, keys...); deleted != 100 {
+		t.Fatalf("deleted = %d, want 100", deleted)
+	}
+}
+
+func TestKetamaStable(t *testing.T) {
+	before := NewKetama([]string{"a", "b", "c"})
+	after := NewKetama([]string{"a", "b", "c", "d"})
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if b, a := before.Get(key), after.Get(key); b != a {
+			if a != "d" {
+				t.Fatalf("key %s moved from %s to %s, want only moves to new shard", key, b, a)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Fatalf("moved %d keys after adding a shard", moved)
+	}
+}
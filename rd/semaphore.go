@@ -0,0 +1,173 @@
+package rd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 信号量 操作 ------------------------------------*/
+
+// semaphoreAcquireScript 公平获取信号量
+// KEYS[1] 持有者及等待者的租约截止时间，KEYS[2] 排队顺序，KEYS[3] 排队序号
+// ARGV[1] token，ARGV[2] 并发上限，ARGV[3] 租约毫秒数
+// 排队序号在前 limit位的 token视为获取成功，租约过期的 token会被清理
+var semaphoreAcquireScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local lease = tonumber(ARGV[3])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for i = 1, #expired do
+	redis.call('ZREM', KEYS[1], expired[i])
+	redis.call('ZREM', KEYS[2], expired[i])
+end
+if not redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[3]), ARGV[1])
+end
+redis.call('ZADD', KEYS[1], now + lease, ARGV[1])
+for i = 1, 3 do
+	redis.call('PEXPIRE', KEYS[i], lease * 2)
+end
+if redis.call('ZRANK', KEYS[2], ARGV[1]) < tonumber(ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// semaphoreRenewScript 续期信号量租约，token不存在或已过期时返回0
+var semaphoreRenewScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) <= now then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+for i = 1, 3 do
+	redis.call('PEXPIRE', KEYS[i], tonumber(ARGV[2]) * 2)
+end
+return 1
+`)
+
+// semaphoreReleaseScript 释放信号量
+var semaphoreReleaseScript = redis.NewScript(`
+redis.call('ZREM', KEYS[2], ARGV[1])
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
+
+// semaphoreCountScript 清理租约过期的 token后返回排队中的 token数
+var semaphoreCountScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for i = 1, #expired do
+	redis.call('ZREM', KEYS[1], expired[i])
+	redis.call('ZREM', KEYS[2], expired[i])
+end
+return redis.call('ZCARD', KEYS[2])
+`)
+
+// Semaphore 基于 redis的分布式计数信号量，用于限制跨实例的并发数
+type Semaphore struct {
+	key   string
+	limit int64
+	lease time.Duration
+	// Acquire阻塞等待时的轮询间隔
+	interval time.Duration
+}
+
+// NewSemaphore 创建信号量，limit为并发上限，lease为租约时长，持有者崩溃后租约到期自动释放
+func NewSemaphore(key string, limit int64, lease time.Duration) *Semaphore {
+	return &Semaphore{
+		key:      key,
+		limit:    limit,
+		lease:    lease,
+		interval: 50 * time.Millisecond,
+	}
+}
+
+// TryAcquire 尝试获取信号量，成功时返回用于释放和续期的 token
+func (s *Semaphore) TryAcquire(ctx context.Context) (string, bool) {
+	token := newToken()
+	ok, err := s.acquire(ctx, token)
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	if !ok {
+		// 未获取成功时退出排队，避免占用排队位置
+		s.Release(ctx, token)
+		return "", false
+	}
+	return token, true
+}
+
+// Acquire 阻塞获取信号量，按排队先后公平获取，直到成功或 ctx结束
+func (s *Semaphore) Acquire(ctx context.Context) (string, error) {
+	token := newToken()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		ok, err := s.acquire(ctx, token)
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+		}
+		if ok {
+			return token, nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Release(context.WithoutCancel(ctx), token)
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Release 释放信号量
+func (s *Semaphore) Release(ctx context.Context, token string) bool {
+	val, err := semaphoreReleaseScript.Run(ctx, client, s.keys(), token).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return val == 1
+}
+
+// Renew 续期信号量租约，返回 false表示租约已过期，需要重新获取
+func (s *Semaphore) Renew(ctx context.Context, token string) bool {
+	val, err := semaphoreRenewScript.Run(ctx, client, s.keys(), token, s.lease.Milliseconds()).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return val == 1
+}
+
+// Count 返回当前持有信号量的数量，不计入租约已过期的持有者
+func (s *Semaphore) Count(ctx context.Context) int64 {
+	size, err := semaphoreCountScript.Run(ctx, client, s.keys()).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	return min(size, s.limit)
+}
+
+func (s *Semaphore) acquire(ctx context.Context, token string) (bool, error) {
+	val, err := semaphoreAcquireScript.Run(ctx, client, s.keys(), token, s.limit, s.lease.Milliseconds()).Int64()
+	return val == 1, err
+}
+
+func (s *Semaphore) keys() []string {
+	return []string{s.key + ":owner", s.key + ":queue", s.key + ":seq"}
+}
+
+// newToken 生成随机 token
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,60 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetTime(now)
+	sem := NewSemaphore("sem", 2, time.Minute)
+
+	a, ok := sem.TryAcquire(ctx)
+	if !ok {
+		t.Fatal("first acquire should succeed")
+	}
+	b, ok := sem.TryAcquire(ctx)
+	if !ok {
+		t.Fatal("second acquire should succeed")
+	}
+	if _, ok = sem.TryAcquire(ctx); ok {
+		t.Fatal("third acquire should fail at the limit")
+	}
+	if n := sem.Count(ctx); n != 2 {
+		t.Fatalf("count = %d, want 2", n)
+	}
+
+	if !sem.Release(ctx, a) || sem.Count(ctx) != 1 {
+		t.Fatalf("count after release = %d, want 1", sem.Count(ctx))
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	c, err := sem.Acquire(waitCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sem.Acquire(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire at the limit err = %v, want deadline exceeded", err)
+	}
+
+	// b续期后 c租约过期，Count不计入已过期的持有者
+	server.SetTime(now.Add(30 * time.Second))
+	if !sem.Renew(ctx, b) {
+		t.Fatal("renew within the lease should succeed")
+	}
+	server.SetTime(now.Add(70 * time.Second))
+	if n := sem.Count(ctx); n != 1 {
+		t.Fatalf("count = %d, want expired holders excluded", n)
+	}
+	if sem.Renew(ctx, c) {
+		t.Fatal("renew after the lease expired should fail")
+	}
+	if _, ok = sem.TryAcquire(ctx); !ok {
+		t.Fatal("acquire should succeed once the expired holder is gone")
+	}
+}
@@ -0,0 +1,341 @@
+package rd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/consts"
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 会话 操作 ------------------------------------*/
+
+// 会话 hash中保留的元数据字段
+const (
+	sessionUserField    = "_uid"
+	sessionCreatedField = "_created"
+)
+
+// ErrSessionNotFound 会话不存在或已过期
+var ErrSessionNotFound = errors.New("session: not found or expired")
+
+// sessionSetScript 会话仍存在时写入字段，避免在已过期的会话上创建没有过期时间的 hash
+// KEYS[1] 会话 key，ARGV[1] 字段，ARGV[2] 值
+var sessionSetScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// sessionRegenerateScript 会话仍存在时更换 key并同步更新用户索引
+// KEYS[1] 旧会话 key，KEYS[2] 新会话 key，KEYS[3] 用户索引 key，ARGV[1] 旧ID，ARGV[2] 新ID，ARGV[3] 索引过期毫秒数
+var sessionRegenerateScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+redis.call('SREM', KEYS[3], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[2])
+redis.call('PEXPIRE', KEYS[3], ARGV[3])
+return 1
+`)
+
+// SessionOptions 会话配置
+type SessionOptions struct {
+	// redis key前缀，默认 session
+	Prefix string
+	// cookie配置，CookieName默认 sid，CookiePath默认 /
+	CookieName string
+	CookiePath string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+	// 空闲超时，每次访问后重新计算，默认30分钟
+	IdleTimeout time.Duration
+	// 绝对有效期，从创建开始计算，到期后必须重新登录，默认7天
+	MaxLifetime time.Duration
+}
+
+// Session 会话
+type Session struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+
+	manager *SessionManager
+	mu      sync.RWMutex
+	values  map[string]string
+}
+
+// SessionManager 基于 redis的会话管理
+type SessionManager struct {
+	opts SessionOptions
+}
+
+// NewSessionManager 创建会话管理
+func NewSessionManager(opts SessionOptions) *SessionManager {
+	if opts.Prefix == "" {
+		opts.Prefix = "session"
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "sid"
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Minute
+	}
+	if opts.MaxLifetime <= 0 {
+		opts.MaxLifetime = 7 * 24 * time.Hour
+	}
+	return &SessionManager{opts: opts}
+}
+
+// Create 为用户创建新会话并写入 cookie
+func (m *SessionManager) Create(ctx context.Context, w http.ResponseWriter, userID string) (*Session, error) {
+	s := &Session{
+		ID:        newSessionID(),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+		manager:   m,
+		values:    map[string]string{},
+	}
+	pipe := client.TxPipeline()
+	pipe.HSet(ctx, m.sessionKey(s.ID), sessionUserField, userID, sessionCreatedField, s.CreatedAt.Unix())
+	pipe.Expire(ctx, m.sessionKey(s.ID), m.ttl(s))
+	pipe.SAdd(ctx, m.userKey(userID), s.ID)
+	pipe.Expire(ctx, m.userKey(userID), m.opts.MaxLifetime)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	m.setCookie(w, s)
+	return s, nil
+}
+
+// Load 根据请求 cookie加载会话并顺延空闲超时，会话不存在或已过期时返回 false
+func (m *SessionManager) Load(ctx context.Context, r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(m.opts.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	return m.Get(ctx, cookie.Value)
+}
+
+// Get 根据会话ID加载会话并顺延空闲超时
+func (m *SessionManager) Get(ctx context.Context, id string) (*Session, bool) {
+	data, err := client.HGetAll(ctx, m.sessionKey(id)).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, false
+	}
+	if len(data) == 0 {
+		return nil, false
+	}
+	created, _ := strconv.ParseInt(data[sessionCreatedField], 10, 64)
+	s := &Session{
+		ID:        id,
+		UserID:    data[sessionUserField],
+		CreatedAt: time.Unix(created, 0),
+		manager:   m,
+		values:    make(map[string]string, len(data)),
+	}
+	for k, v := range data {
+		if !strings.HasPrefix(k, "_") {
+			s.values[k] = v
+		}
+	}
+	ttl := m.ttl(s)
+	if ttl <= 0 {
+		// 超过绝对有效期
+		m.destroy(ctx, s)
+		return nil, false
+	}
+	Expire(ctx, m.sessionKey(id), ttl)
+	return s, true
+}
+
+// Regenerate 更换会话ID并保留会话数据，登录或权限变更后调用以防止会话固定攻击
+// 会话已过期时返回 ErrSessionNotFound
+func (m *SessionManager) Regenerate(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	newID := newSessionID()
+	keys := []string{m.sessionKey(s.ID), m.sessionKey(newID), m.userKey(s.UserID)}
+	val, err := sessionRegenerateScript.Run(ctx, client, keys, s.ID, newID, m.opts.MaxLifetime.Milliseconds()).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	if val == 0 {
+		return ErrSessionNotFound
+	}
+	s.ID = newID
+	m.setCookie(w, s)
+	return nil
+}
+
+// Destroy 销毁会话并清除 cookie
+func (m *SessionManager) Destroy(ctx context.Context, w http.ResponseWriter, s *Session) {
+	m.destroy(ctx, s)
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    "",
+		Path:     m.opts.CookiePath,
+		Domain:   m.opts.Domain,
+		MaxAge:   -1,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+// DestroyUser 销毁用户的所有会话，用于退出所有设备，返回销毁的会话数
+func (m *SessionManager) DestroyUser(ctx context.Context, userID string) int64 {
+	ids := SMembers(ctx, m.userKey(userID))
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, m.sessionKey(id))
+	}
+	keys = append(keys, m.userKey(userID))
+	val, err := client.Del(ctx, keys...).Result()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+	// 不计入用户索引 key本身
+	return max(val-1, 0)
+}
+
+// UserSessions 返回用户当前有效的会话ID，并清理索引中已过期的会话
+func (m *SessionManager) UserSessions(ctx context.Context, userID string) []string {
+	ids := SMembers(ctx, m.userKey(userID))
+	if len(ids) == 0 {
+		return nil
+	}
+	pipe := client.Pipeline()
+	exists := make([]*redis.IntCmd, len(ids))
+	for i, id := range ids {
+		exists[i] = pipe.Exists(ctx, m.sessionKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil
+	}
+	var alive []string
+	var dead []interface{}
+	for i, id := range ids {
+		if exists[i].Val() == 1 {
+			alive = append(alive, id)
+		} else {
+			dead = append(dead, id)
+		}
+	}
+	if len(dead) > 0 {
+		SRem(ctx, m.userKey(userID), dead...)
+	}
+	return alive
+}
+
+// Middleware 会话中间件，将请求对应的会话放入 context，通过 SessionFromContext获取
+func (m *SessionManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := m.Load(r.Context(), r); ok {
+			r = r.WithContext(context.WithValue(r.Context(), consts.SessionKey, s))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SessionFromContext 从 context中获取会话，未登录时返回 nil
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(consts.SessionKey).(*Session)
+	return s
+}
+
+// Get 获取会话数据
+func (s *Session) Get(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+// Set 设置会话数据并立即保存，key不能以 _开头，会话已过期时返回 ErrSessionNotFound
+func (s *Session) Set(ctx context.Context, key, value string) error {
+	if strings.HasPrefix(key, "_") {
+		return errors.New("session key must not start with _")
+	}
+	val, err := sessionSetScript.Run(ctx, client, []string{s.manager.sessionKey(s.ID)}, key, value).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return err
+	}
+	if val == 0 {
+		return ErrSessionNotFound
+	}
+	s.mu.Lock()
+	s.values[key] = value
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete 删除会话数据
+func (s *Session) Delete(ctx context.Context, key string) {
+	HDel(ctx, s.manager.sessionKey(s.ID), key)
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+}
+
+func (m *SessionManager) destroy(ctx context.Context, s *Session) {
+	pipe := client.TxPipeline()
+	pipe.Del(ctx, m.sessionKey(s.ID))
+	pipe.SRem(ctx, m.userKey(s.UserID), s.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+	}
+}
+
+// ttl 返回会话本次的过期时间，取空闲超时和剩余绝对有效期中较小的值
+func (m *SessionManager) ttl(s *Session) time.Duration {
+	return min(m.opts.IdleTimeout, time.Until(s.CreatedAt.Add(m.opts.MaxLifetime)))
+}
+
+func (m *SessionManager) setCookie(w http.ResponseWriter, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    s.ID,
+		Path:     m.opts.CookiePath,
+		Domain:   m.opts.Domain,
+		Expires:  s.CreatedAt.Add(m.opts.MaxLifetime),
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+func (m *SessionManager) sessionKey(id string) string {
+	return m.opts.Prefix + ":sess:" + id
+}
+
+func (m *SessionManager) userKey(userID string) string {
+	return m.opts.Prefix + ":user:" + userID
+}
+
+// newSessionID 生成256位随机会话ID
+func newSessionID() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
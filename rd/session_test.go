@@ -0,0 +1,86 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	m := NewSessionManager(SessionOptions{IdleTimeout: time.Minute, MaxLifetime: time.Hour})
+
+	rec := httptest.NewRecorder()
+	s, err := m.Create(ctx, rec, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "role", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "_uid", "u2"); err == nil {
+		t.Fatal("reserved fields should be rejected")
+	}
+
+	var seen *Session
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = SessionFromContext(r.Context()) }))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	server.FastForward(30 * time.Second)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == nil || seen.UserID != "u1" || seen.Get("role") != "admin" {
+		t.Fatalf("session from context = %+v", seen)
+	}
+	if ttl := server.TTL(m.sessionKey(s.ID)); ttl != time.Minute {
+		t.Fatalf("ttl after load = %s, want the idle timeout renewed", ttl)
+	}
+
+	oldID := s.ID
+	if err = m.Regenerate(ctx, httptest.NewRecorder(), s); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(m.sessionKey(oldID)) || !server.Exists(m.sessionKey(s.ID)) {
+		t.Fatal("regenerate should move the session to the new id")
+	}
+	if ids := m.UserSessions(ctx, "u1"); !slices.Equal(ids, []string{s.ID}) {
+		t.Fatalf("user sessions = %v, want only the new id", ids)
+	}
+
+	other, _ := m.Create(ctx, httptest.NewRecorder(), "u1")
+	if n := m.DestroyUser(ctx, "u1"); n != 2 {
+		t.Fatalf("destroyed = %d, want 2", n)
+	}
+	if _, ok := m.Get(ctx, other.ID); ok {
+		t.Fatal("destroyed session should not load")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	m := NewSessionManager(SessionOptions{IdleTimeout: time.Minute})
+	s, _ := m.Create(ctx, httptest.NewRecorder(), "u1")
+	server.FastForward(2 * time.Minute)
+
+	if err := s.Set(ctx, "k", "v"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("set err = %v, want ErrSessionNotFound", err)
+	}
+	if server.Exists(m.sessionKey(s.ID)) {
+		t.Fatal("set on an expired session should not recreate it")
+	}
+	oldID := s.ID
+	if err := m.Regenerate(ctx, httptest.NewRecorder(), s); !errors.Is(err, ErrSessionNotFound) || s.ID != oldID {
+		t.Fatalf("regenerate err = %v, want ErrSessionNotFound with the id unchanged", err)
+	}
+	if members, _ := server.Members(m.userKey("u1")); !slices.Equal(members, []string{oldID}) {
+		t.Fatalf("user index = %v, want unchanged", members)
+	}
+	if ids := m.UserSessions(ctx, "u1"); len(ids) != 0 {
+		t.Fatalf("user sessions = %v, want the expired session pruned", ids)
+	}
+}
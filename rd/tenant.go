@@ -0,0 +1,541 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oho-panda/utils/v2/consts"
+	"github.com/oho-panda/utils/v2/logs"
+	"github.com/redis/go-redis/v9"
+)
+
+/*------------------------------------ 多租户隔离 操作 ------------------------------------*/
+
+var (
+	// ErrInvalidTenant 租户ID只能包含字母、数字、下划线、中划线和点
+	ErrInvalidTenant = errors.New("redis: invalid tenant id")
+	// ErrTenantCommand 租户 ctx下不允许执行无法确定 key位置或跨租户的命令
+	ErrTenantCommand = errors.New("redis: command not allowed in tenant context")
+	// ErrTenantQuota 租户用量超过配额，只允许读取和删除
+	ErrTenantQuota = errors.New("redis: tenant quota exceeded")
+)
+
+// 租户管理数据的 key，不在任何租户前缀下
+const (
+	tenantsKey         = "tenants"
+	tenantUsagePrefix  = "tenants:usage:"
+	tenantQuotaPrefix  = "tenants:quota:"
+	tenantKeyPrefixFmt = "tenant:%s:"
+)
+
+// tenantQuotaCacheTTL 各实例本地缓存租户是否超出配额的时长，超出配额的状态保存在用量 hash中由所有实例共享
+const tenantQuotaCacheTTL = 5 * time.Second
+
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// WithTenant 返回带租户ID的 ctx，开启租户隔离后该 ctx下的命令只能访问 TenantPrefix(tenantID)下的 key
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, consts.TenantKey, tenantID)
+}
+
+// TenantFromContext 获取 ctx中的租户ID
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(consts.TenantKey).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// TenantPrefix 返回租户的 key前缀
+func TenantPrefix(tenantID string) string {
+	return fmt.Sprintf(tenantKeyPrefixFmt, tenantID)
+}
+
+// TenantQuota 租户配额，0为不限制
+type TenantQuota struct {
+	Keys   int64 `json:"keys"`
+	Memory int64 `json:"memory"`
+}
+
+// TenantUsage 租户用量，由 RefreshUsage扫描统计
+type TenantUsage struct {
+	Keys      int64     `json:"keys"`
+	Memory    int64     `json:"memory"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// TenantIsolation 租户隔离，为租户 ctx下的命令自动添加 key前缀，并在用量超过配额时拒绝写入
+type TenantIsolation struct {
+	quota TenantQuota
+
+	mu       sync.RWMutex
+	exceeded map[string]quotaState
+	known    sync.Map
+}
+
+type quotaState struct {
+	exceeded  bool
+	checkedAt time.Time
+}
+
+// EnableTenantIsolation 为全局 redis客户端开启租户隔离，需在 InitRedisClient之后调用，quota为默认配额
+// 命令的 key、KEYS和 SCAN的模式会加上租户前缀，返回结果中的 key会去掉前缀，调用方无需感知租户
+// Lua脚本只隔离 KEYS参数，脚本内访问的 key都需通过 KEYS传入，通过 ARGV拼接的 key不受保护
+// 读写分离在租户隔离之前安装时，发往从节点的租户命令同样经过租户隔离，熔断降级按租户区分缓存，隔离不受 hook顺序影响
+func EnableTenantIsolation(quota TenantQuota) *TenantIsolation {
+	t := &TenantIsolation{quota: quota, exceeded: map[string]quotaState{}}
+	client.AddHook(t)
+	for _, r := range globalReplicas() {
+		r.tenant.Store(t)
+	}
+	return t
+}
+
+// SetQuota 设置租户配额，覆盖默认配额
+func (t *TenantIsolation) SetQuota(ctx context.Context, tenantID string, quota TenantQuota) bool {
+	ctx = withoutTenant(ctx)
+	if !HMSet(ctx, tenantQuotaPrefix+tenantID, map[string]interface{}{"keys": quota.Keys, "memory": quota.Memory}) {
+		return false
+	}
+	if usage, ok := t.Usage(ctx, tenantID); ok {
+		t.setExceeded(ctx, tenantID, exceedsQuota(usage, quota))
+	}
+	return true
+}
+
+// Quota 返回租户配额，未单独设置时返回默认配额
+func (t *TenantIsolation) Quota(ctx context.Context, tenantID string) TenantQuota {
+	m := HGetAll(withoutTenant(ctx), tenantQuotaPrefix+tenantID)
+	if len(m) == 0 {
+		return t.quota
+	}
+	var quota TenantQuota
+	quota.Keys, _ = strconv.ParseInt(m["keys"], 10, 64)
+	quota.Memory, _ = strconv.ParseInt(m["memory"], 10, 64)
+	return quota
+}
+
+// Usage 返回最近一次统计的租户用量
+func (t *TenantIsolation) Usage(ctx context.Context, tenantID string) (*TenantUsage, bool) {
+	m := HGetAll(withoutTenant(ctx), tenantUsagePrefix+tenantID)
+	if len(m) == 0 {
+		return nil, false
+	}
+	usage := &TenantUsage{}
+	usage.Keys, _ = strconv.ParseInt(m["keys"], 10, 64)
+	usage.Memory, _ = strconv.ParseInt(m["memory"], 10, 64)
+	checkedAt, _ := strconv.ParseInt(m["checked_at"], 10, 64)
+	usage.CheckedAt = time.Unix(checkedAt, 0)
+	return usage, true
+}
+
+// RefreshUsage 扫描租户的所有 key统计数量和内存占用，保存用量并更新是否超出配额
+func (t *TenantIsolation) RefreshUsage(ctx context.Context, tenantID string) (*TenantUsage, error) {
+	ctx = withoutTenant(ctx)
+	usage := &TenantUsage{CheckedAt: time.Now()}
+	err := scanTenant(ctx, tenantID, func(keys []string) error {
+		pipe := client.Pipeline()
+		memories := make([]*redis.IntCmd, len(keys))
+		for i, key := range keys {
+			memories[i] = pipe.MemoryUsage(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !isBatchKeyError(err) {
+			return err
+		}
+		for _, m := range memories {
+			if m.Err() == nil {
+				usage.Keys++
+				usage.Memory += m.Val()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return nil, err
+	}
+	HMSet(ctx, tenantUsagePrefix+tenantID, map[string]interface{}{
+		"keys": usage.Keys, "memory": usage.Memory, "checked_at": usage.CheckedAt.Unix(),
+	})
+	t.setExceeded(ctx, tenantID, exceedsQuota(usage, t.Quota(ctx, tenantID)))
+	return usage, nil
+}
+
+// Run 每隔 interval统计一次所有写入过数据的租户的用量，阻塞直到 ctx结束
+func (t *TenantIsolation) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, tenantID := range SMembers(withoutTenant(ctx), tenantsKey) {
+			_, _ = t.RefreshUsage(ctx, tenantID)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeTenant 分批扫描并删除租户的所有 key及用量、配额记录，返回删除的 key数量
+func PurgeTenant(ctx context.Context, tenantID string) (int64, error) {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return 0, ErrInvalidTenant
+	}
+	ctx = withoutTenant(ctx)
+	var deleted int64
+	err := scanTenant(ctx, tenantID, func(keys []string) error {
+		n, err := client.Unlink(ctx, keys...).Result()
+		deleted += n
+		return err
+	})
+	if err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return deleted, err
+	}
+	client.Del(ctx, tenantUsagePrefix+tenantID, tenantQuotaPrefix+tenantID)
+	SRem(ctx, tenantsKey, tenantID)
+	logs.CtxInfo(ctx, "redis tenant %s purged, deleted %d keys", tenantID, deleted)
+	return deleted, nil
+}
+
+func (t *TenantIsolation) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (t *TenantIsolation) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		tenantID, ok := TenantFromContext(ctx)
+		if !ok {
+			return next(ctx, cmd)
+		}
+		if err := t.check(ctx, tenantID, cmd); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		// 之后的 hook看到的是已加前缀的命令，ctx中不再带租户
+		prefix, ctx := TenantPrefix(tenantID), withoutTenant(ctx)
+		if scan, ok := cmd.(*redis.ScanCmd); ok && cmd.Name() == "scan" {
+			return tenantScan(ctx, next, scan, prefix)
+		}
+		prefixKeys(cmd, prefix)
+		err := next(ctx, cmd)
+		stripKeys(cmd, prefix)
+		return err
+	}
+}
+
+func (t *TenantIsolation) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		tenantID, ok := TenantFromContext(ctx)
+		if !ok {
+			return next(ctx, cmds)
+		}
+		for _, cmd := range cmds {
+			err := t.check(ctx, tenantID, cmd)
+			if err == nil && cmd.Name() == "scan" {
+				err = ErrTenantCommand
+			}
+			if err != nil {
+				for _, c := range cmds {
+					c.SetErr(err)
+				}
+				return err
+			}
+		}
+		prefix, ctx := TenantPrefix(tenantID), withoutTenant(ctx)
+		for _, cmd := range cmds {
+			prefixKeys(cmd, prefix)
+		}
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			stripKeys(cmd, prefix)
+		}
+		return err
+	}
+}
+
+// check 校验租户ID、命令是否支持及写命令的配额
+func (t *TenantIsolation) check(ctx context.Context, tenantID string, cmd redis.Cmder) error {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return ErrInvalidTenant
+	}
+	name := cmd.Name()
+	if _, ok := tenantKeySpecs[name]; !ok && !tenantKeyless[name] {
+		return fmt.Errorf("%w: %s", ErrTenantCommand, name)
+	}
+	if isReadCommand(cmd) || tenantShrinkCommands[name] || tenantKeyless[name] {
+		return nil
+	}
+	if _, loaded := t.known.LoadOrStore(tenantID, true); !loaded {
+		SAdd(withoutTenant(ctx), tenantsKey, tenantID)
+	}
+	if t.isExceeded(ctx, tenantID) {
+		return ErrTenantQuota
+	}
+	return nil
+}
+
+// isExceeded 判断租户是否超出配额，优先使用本地缓存，过期后从用量 hash中读取
+func (t *TenantIsolation) isExceeded(ctx context.Context, tenantID string) bool {
+	t.mu.RLock()
+	state, ok := t.exceeded[tenantID]
+	t.mu.RUnlock()
+	if ok && time.Since(state.checkedAt) < tenantQuotaCacheTTL {
+		return state.exceeded
+	}
+	ctx = withoutTenant(ctx)
+	val, err := client.HGet(ctx, tenantUsagePrefix+tenantID, "exceeded").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		// 读取失败时沿用上一次的结果
+		logs.CtxWarn(ctx, err.Error())
+		return state.exceeded
+	}
+	t.cacheExceeded(tenantID, val == "1")
+	return val == "1"
+}
+
+// setExceeded 保存租户是否超出配额，其他实例在本地缓存过期后生效
+func (t *TenantIsolation) setExceeded(ctx context.Context, tenantID string, exceeded bool) {
+	HMSet(ctx, tenantUsagePrefix+tenantID, map[string]interface{}{"exceeded": exceeded})
+	t.cacheExceeded(tenantID, exceeded)
+}
+
+func (t *TenantIsolation) cacheExceeded(tenantID string, exceeded bool) {
+	t.mu.Lock()
+	t.exceeded[tenantID] = quotaState{exceeded: exceeded, checkedAt: time.Now()}
+	t.mu.Unlock()
+}
+
+func exceedsQuota(usage *TenantUsage, quota TenantQuota) bool {
+	return (quota.Keys > 0 && usage.Keys >= quota.Keys) || (quota.Memory > 0 && usage.Memory >= quota.Memory)
+}
+
+// withTenantKeys 在租户 ctx下为命令的 key临时加上租户前缀后执行 fn，用于在租户隔离之前执行的 hook按租户区分命令
+// 租户ID不合法时不执行 fn并返回 false
+func withTenantKeys(ctx context.Context, cmd redis.Cmder, fn func(cmd redis.Cmder) bool) bool {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return fn(cmd)
+	}
+	if !tenantIDPattern.MatchString(tenantID) {
+		return false
+	}
+	prefix := TenantPrefix(tenantID)
+	prefixKeys(cmd, prefix)
+	defer stripKeys(cmd, prefix)
+	return fn(cmd)
+}
+
+// withoutTenant 返回不带租户的 ctx，用于访问租户管理数据及将已加前缀的命令交给之后的 hook
+func withoutTenant(ctx context.Context) context.Context {
+	if _, ok := TenantFromContext(ctx); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, consts.TenantKey, "")
+}
+
+// scanTenant 分批扫描租户的 key
+func scanTenant(ctx context.Context, tenantID string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, TenantPrefix(tenantID)+"*", 500).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// tenantScan 为 SCAN加上租户前缀的 MATCH模式，并去掉结果中的前缀
+func tenantScan(ctx context.Context, next redis.ProcessHook, cmd *redis.ScanCmd, prefix string) error {
+	args := cmd.Args()
+	scanArgs := make([]interface{}, 0, len(args)+2)
+	match := false
+	for i := 0; i < len(args); i++ {
+		scanArgs = append(scanArgs, args[i])
+		if s, ok := args[i].(string); ok && strings.EqualFold(s, "match") && i+1 < len(args) {
+			i++
+			scanArgs = append(scanArgs, prefix+fmt.Sprint(args[i]))
+			match = true
+		}
+	}
+	if !match {
+		scanArgs = append(scanArgs, "match", prefix+"*")
+	}
+	scan := redis.NewScanCmd(ctx, nil, scanArgs...)
+	err := next(ctx, scan)
+	keys, cursor := scan.Val()
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], prefix)
+	}
+	cmd.SetVal(keys, cursor)
+	cmd.SetErr(scan.Err())
+	return err
+}
+
+// keySpec 命令中 key参数的位置，last为负数时从末尾倒数，numkeys大于0时 key个数由该位置的参数指定
+type keySpec struct {
+	first, last, step int
+	numkeys           int
+}
+
+// tenantKeySpecs 租户 ctx下允许的命令及其 key位置
+var tenantKeySpecs = func() map[string]keySpec {
+	specs := map[string]keySpec{}
+	single := `get set setnx setex psetex getset getdel getex append incr incrby incrbyfloat decr decrby strlen
+		getrange setrange setbit getbit bitcount bitpos bitfield bitfield_ro
+		expire pexpire expireat pexpireat expiretime pexpiretime persist ttl pttl type dump restore keys
+		hset hsetnx hget hmset hmget hgetall hdel hexists hincrby hincrbyfloat hkeys hvals hlen hstrlen hrandfield hscan
+		lpush rpush lpushx rpushx lpop rpop llen lrange lindex lset linsert lrem ltrim lpos
+		sadd srem smembers sismember smismember scard spop srandmember sscan
+		zadd zrem zscore zincrby zcard zcount zrange zrangebyscore zrevrange zrevrangebyscore zrangebylex zrevrangebylex
+		zlexcount zrank zrevrank zremrangebyrank zremrangebyscore zremrangebylex zpopmin zpopmax zmscore zrandmember zscan
+		geoadd geopos geodist geohash georadius georadius_ro georadiusbymember georadiusbymember_ro geosearch
+		pfadd xadd xrange xrevrange xlen xdel xtrim xack xpending xclaim xautoclaim xsetid`
+	for _, name := range strings.Fields(single) {
+		specs[name] = keySpec{first: 1, last: 1, step: 1}
+	}
+	for _, name := range strings.Fields(`del exists unlink touch mget sinter sunion sdiff pfcount pfmerge sinterstore sunionstore sdiffstore watch`) {
+		specs[name] = keySpec{first: 1, last: -1, step: 1}
+	}
+	for _, name := range strings.Fields(`rename renamenx rpoplpush smove lmove copy lcs geosearchstore zrangestore blmove brpoplpush`) {
+		specs[name] = keySpec{first: 1, last: 2, step: 1}
+	}
+	for _, name := range strings.Fields(`blpop brpop bzpopmin bzpopmax`) {
+		specs[name] = keySpec{first: 1, last: -2, step: 1}
+	}
+	for _, name := range strings.Fields(`eval evalsha eval_ro evalsha_ro fcall fcall_ro`) {
+		specs[name] = keySpec{numkeys: 2}
+	}
+	for _, name := range strings.Fields(`zunionstore zinterstore zdiffstore`) {
+		specs[name] = keySpec{first: 1, last: 1, step: 1, numkeys: 2}
+	}
+	for _, name := range strings.Fields(`zunion zinter zdiff sintercard zintercard lmpop zmpop`) {
+		specs[name] = keySpec{numkeys: 1}
+	}
+	for _, name := range strings.Fields(`blmpop bzmpop`) {
+		specs[name] = keySpec{numkeys: 2}
+	}
+	specs["mset"] = keySpec{first: 1, last: -1, step: 2}
+	specs["msetnx"] = keySpec{first: 1, last: -1, step: 2}
+	specs["bitop"] = keySpec{first: 2, last: -1, step: 1}
+	specs["xgroup"] = keySpec{first: 2, last: 2, step: 1}
+	specs["xinfo"] = keySpec{first: 2, last: 2, step: 1}
+	specs["memory"] = keySpec{first: 2, last: 2, step: 1}
+	specs["object"] = keySpec{first: 2, last: 2, step: 1}
+	specs["xread"] = keySpec{}
+	specs["xreadgroup"] = keySpec{}
+	specs["scan"] = keySpec{}
+	return specs
+}()
+
+// tenantKeyless 租户 ctx下允许的无 key命令
+var tenantKeyless = map[string]bool{
+	"ping": true, "echo": true, "time": true, "multi": true, "exec": true, "discard": true, "unwatch": true, "script": true,
+}
+
+// tenantShrinkCommands 超出配额时仍允许执行的删除类命令
+var tenantShrinkCommands = map[string]bool{
+	"del": true, "unlink": true, "expire": true, "pexpire": true, "expireat": true, "pexpireat": true, "getdel": true,
+	"hdel": true, "lpop": true, "rpop": true, "ltrim": true, "lrem": true, "srem": true, "spop": true,
+	"zrem": true, "zremrangebyrank": true, "zremrangebyscore": true, "zremrangebylex": true, "zpopmin": true, "zpopmax": true,
+	"xdel": true, "xtrim": true, "xack": true,
+}
+
+// keyIndexes 返回命令中 key参数的下标
+func keyIndexes(cmd redis.Cmder) []int {
+	args := cmd.Args()
+	spec := tenantKeySpecs[cmd.Name()]
+	var indexes []int
+	if spec.step > 0 {
+		last := spec.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := spec.first; i <= last && i < len(args); i += spec.step {
+			indexes = append(indexes, i)
+		}
+	}
+	if spec.numkeys > 0 && spec.numkeys < len(args) {
+		n, _ := strconv.Atoi(fmt.Sprint(args[spec.numkeys]))
+		for i := spec.numkeys + 1; i <= spec.numkeys+n && i < len(args); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	if name := cmd.Name(); name == "georadius" || name == "georadiusbymember" {
+		// GEORADIUS ... [STORE key] [STOREDIST key]，选项在 unit之后
+		for i := 5; i < len(args)-1; i++ {
+			if s, ok := args[i].(string); ok && (strings.EqualFold(s, "store") || strings.EqualFold(s, "storedist")) {
+				indexes = append(indexes, i+1)
+				i++
+			}
+		}
+	}
+	if name := cmd.Name(); name == "xread" || name == "xreadgroup" {
+		// XREAD ... STREAMS key [key ...] id [id ...]
+		for i, arg := range args {
+			if s, ok := arg.(string); ok && strings.EqualFold(s, "streams") {
+				n := (len(args) - i - 1) / 2
+				for j := i + 1; j <= i+n; j++ {
+					indexes = append(indexes, j)
+				}
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// prefixKeys 为命令的 key参数加上租户前缀
+func prefixKeys(cmd redis.Cmder, prefix string) {
+	args := cmd.Args()
+	for _, i := range keyIndexes(cmd) {
+		args[i] = prefix + fmt.Sprint(args[i])
+	}
+}
+
+// stripKeys 去掉命令参数及返回结果中的租户前缀
+func stripKeys(cmd redis.Cmder, prefix string) {
+	args := cmd.Args()
+	for _, i := range keyIndexes(cmd) {
+		if s, ok := args[i].(string); ok {
+			args[i] = strings.TrimPrefix(s, prefix)
+		}
+	}
+	switch c := cmd.(type) {
+	case *redis.StringSliceCmd:
+		// KEYS返回 key列表，BLPOP、BRPOP返回 [key, value]
+		switch cmd.Name() {
+		case "keys":
+			vals := c.Val()
+			for i := range vals {
+				vals[i] = strings.TrimPrefix(vals[i], prefix)
+			}
+		case "blpop", "brpop":
+			if vals := c.Val(); len(vals) > 0 {
+				vals[0] = strings.TrimPrefix(vals[0], prefix)
+			}
+		}
+	case *redis.XStreamSliceCmd:
+		vals := c.Val()
+		for i := range vals {
+			vals[i].Stream = strings.TrimPrefix(vals[i].Stream, prefix)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	server := startRedis(t)
+	isolation := EnableTenantIsolation(TenantQuota{})
+	bg := context.Background()
+	a, b := WithTenant(bg, "a"), WithTenant(bg, "b")
+
+	SetEX(a, "user:1", "alice", time.Minute)
+	HSet(b, "user:1", "name", "bob")
+	if !server.Exists("tenant:a:user:1") || !server.Exists("tenant:b:user:1") {
+		t.Fatalf("keys should be prefixed, got %v", server.Keys())
+	}
+	if _, v := Get(a, "user:1"); v != "alice" {
+		t.Fatalf("tenant a get = %q", v)
+	}
+	if ok, _ := Get(b, "user:1"); ok {
+		t.Fatal("tenant b should not read the string of tenant a")
+	}
+
+	client.MSet(a, "x", "1", "y", "2")
+	if vals := client.MGet(a, "x", "y").Val(); !slices.Equal(vals, []interface{}{"1", "2"}) {
+		t.Fatalf("mget = %v", vals)
+	}
+	keys := client.Keys(a, "*").Val()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"user:1", "x", "y"}) {
+		t.Fatalf("keys = %v, want unprefixed keys of tenant a only", keys)
+	}
+	scanned, _ := client.Scan(a, 0, "", 100).Val()
+	slices.Sort(scanned)
+	if !slices.Equal(scanned, keys) {
+		t.Fatalf("scan = %v, want %v", scanned, keys)
+	}
+	if v := redis.NewScript(`return redis.call('GET', KEYS[1])`).Run(a, client, []string{"x"}).Val(); v != "1" {
+		t.Fatalf("eval = %v", v)
+	}
+	pipe := client.Pipeline()
+	get := pipe.Get(b, "x")
+	pipe.Incr(b, "x")
+	_, _ = pipe.Exec(b)
+	if !errors.Is(get.Err(), redis.Nil) || !server.Exists("tenant:b:x") {
+		t.Fatalf("pipeline get = %v, keys %v", get.Err(), server.Keys())
+	}
+	if err := client.FlushDB(a).Err(); !errors.Is(err, ErrTenantCommand) {
+		t.Fatalf("flushdb err = %v, want ErrTenantCommand", err)
+	}
+	if err := client.Get(WithTenant(bg, "a:b"), "x").Err(); !errors.Is(err, ErrInvalidTenant) {
+		t.Fatalf("invalid tenant err = %v", err)
+	}
+
+	usage, err := isolation.RefreshUsage(bg, "a")
+	if err != nil || usage.Keys != 3 {
+		t.Fatalf("usage = %+v %v, want 3 keys", usage, err)
+	}
+	isolation.SetQuota(bg, "a", TenantQuota{Keys: 3})
+	if err = client.Set(a, "z", "3", 0).Err(); !errors.Is(err, ErrTenantQuota) {
+		t.Fatalf("set over quota err = %v", err)
+	}
+	if !Del(a, "x") {
+		t.Fatal("delete should be allowed over quota")
+	}
+	if !slices.Contains(SMembers(bg, tenantsKey), "a") {
+		t.Fatal("tenant a should be registered")
+	}
+
+	deleted, err := PurgeTenant(bg, "a")
+	if err != nil || deleted != 2 {
+		t.Fatalf("purge = %d %v, want 2", deleted, err)
+	}
+	for _, key := range server.Keys() {
+		if key != tenantsKey && !strings.HasPrefix(key, "tenant:b:") {
+			t.Fatalf("key %s should be purged", key)
+		}
+	}
+}
+
+func TestTenantHookOrder(t *testing.T) {
+	primary := startRedis(t)
+	replica := miniredis.RunT(t)
+	router := EnableReplicas([]string{replica.Addr()}, "", time.Second, ReplicaRoundRobin)
+	defer router.Close()
+	cb := EnableCircuitBreaker(BreakerOptions{OpenTimeout: time.Minute, Fallback: NewLocalCacheFallback(time.Minute)})
+	bg := context.Background()
+	a, b := WithTenant(bg, "a"), WithTenant(bg, "b")
+
+	_ = primary.Set("tenant:a:k", "a")
+	_ = replica.Set("tenant:a:k", "a-replica")
+	_ = replica.Set("k", "shared")
+	if _, v := Get(a, "k"); v != "shared" {
+		t.Fatalf("read before isolation = %q, tenant ctx alone should not change routing", v)
+	}
+
+	EnableTenantIsolation(TenantQuota{})
+	if _, v := Get(a, "k"); v != "a-replica" {
+		t.Fatalf("tenant read = %q, want the prefixed key on the replica", v)
+	}
+	if _, v := Get(WithPrimary(a), "k"); v != "a" {
+		t.Fatalf("tenant primary read = %q", v)
+	}
+
+	cb.transition(bg, BreakerOpen)
+	if v, err := client.Get(WithPrimary(a), "k").Result(); err != nil || v != "a" {
+		t.Fatalf("tenant a read while open = %q %v, want the fallback", v, err)
+	}
+	if v, err := client.Get(WithPrimary(b), "k").Result(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("tenant b read while open = %q %v, want ErrCircuitOpen", v, err)
+	}
+}
+
+func TestTenantTagInvalidation(t *testing.T) {
+	server := startRedis(t)
+	EnableTenantIsolation(TenantQuota{})
+	bg := context.Background()
+	a, b := WithTenant(bg, "a"), WithTenant(bg, "b")
+
+	SetWithTags(a, "k", "a", time.Minute, "t1", "t2")
+	SetWithTags(b, "k", "b", time.Minute, "t1")
+	SetWithTags(bg, "k", "shared", time.Minute, "t1")
+	if members := TagMembers(a, "t2"); !slices.Equal(members, []string{"k"}) {
+		t.Fatalf("tag members = %v, want unprefixed keys", members)
+	}
+
+	if n := InvalidateTag(a, "t1"); n != 1 {
+		t.Fatalf("invalidated = %d, want 1", n)
+	}
+	for _, key := range []string{"tenant:a:k", "tenant:a:cache:keytags:k", "tenant:a:cache:tag:t1", "tenant:a:cache:tag:t2"} {
+		if server.Exists(key) {
+			t.Fatalf("%s should be removed", key)
+		}
+	}
+	if _, v := Get(b, "k"); v != "b" || len(TagMembers(b, "t1")) != 1 {
+		t.Fatalf("tenant b cache = %q, tags %v", v, TagMembers(b, "t1"))
+	}
+	if _, v := Get(bg, "k"); v != "shared" || len(TagMembers(bg, "t1")) != 1 {
+		t.Fatalf("shared cache = %q, tags %v", v, TagMembers(bg, "t1"))
+	}
+}
+
+func TestTenantQuotaShared(t *testing.T) {
+	startRedis(t)
+	isolation := EnableTenantIsolation(TenantQuota{})
+	other := &TenantIsolation{exceeded: map[string]quotaState{}}
+	bg := context.Background()
+	a := WithTenant(bg, "a")
+
+	if err := client.Set(a, "x", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	other.SetQuota(bg, "a", TenantQuota{Keys: 1})
+	if _, err := other.RefreshUsage(bg, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(a, "y", "1", 0).Err(); err != nil {
+		t.Fatalf("set within local cache ttl err = %v", err)
+	}
+	// 本地缓存过期后读取其他实例保存的配额状态
+	delete(isolation.exceeded, "a")
+	if err := client.Set(a, "z", "1", 0).Err(); !errors.Is(err, ErrTenantQuota) {
+		t.Fatalf("set err = %v, want ErrTenantQuota from shared state", err)
+	}
+
+	other.SetQuota(bg, "a", TenantQuota{})
+	delete(isolation.exceeded, "a")
+	if err := client.Set(a, "z", "1", 0).Err(); err != nil {
+		t.Fatalf("set after quota lifted err = %v", err)
+	}
+}
+
+func TestTenantGeoStore(t *testing.T) {
+	server := startRedis(t)
+	EnableTenantIsolation(TenantQuota{})
+	a := WithTenant(context.Background(), "a")
+	client.GeoAdd(a, "pts", &redis.GeoLocation{Name: "p", Longitude: 13.361389, Latitude: 38.115556})
+
+	q := &redis.GeoRadiusQuery{Radius: 10, Unit: "km", Store: "tenant:b:stolen"}
+	if err := client.GeoRadiusStore(a, "pts", 13.361389, 38.115556, q).Err(); err != nil {
+		t.Fatal(err)
+	}
+	q = &redis.GeoRadiusQuery{Radius: 10, Unit: "km", StoreDist: "tenant:b:dist"}
+	if err := client.GeoRadiusByMemberStore(a, "pts", "p", q).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("tenant:b:stolen") || server.Exists("tenant:b:dist") {
+		t.Fatalf("store destination escaped the tenant, keys %v", server.Keys())
+	}
+	if !server.Exists("tenant:a:tenant:b:stolen") || !server.Exists("tenant:a:tenant:b:dist") {
+		t.Fatalf("store destination should be prefixed, keys %v", server.Keys())
+	}
+}
@@ -0,0 +1,155 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/oho-panda/utils/v2/logs"
+)
+
+/*------------------------------------ 时间序列 操作 ------------------------------------*/
+
+// Resolution 时间序列的统计粒度，Retention为每个桶的保留时长
+type Resolution struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// 常用统计粒度
+var (
+	ResolutionMinute = Resolution{Step: time.Minute, Retention: 48 * time.Hour}
+	ResolutionHour   = Resolution{Step: time.Hour, Retention: 30 * 24 * time.Hour}
+	ResolutionDay    = Resolution{Step: 24 * time.Hour, Retention: 400 * 24 * time.Hour}
+)
+
+// Point 时间序列中的一个桶，Time为桶的起始时间
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value int64     `json:"value"`
+}
+
+// Series 时间序列
+type Series []Point
+
+// TimeSeries 多粒度时间序列计数器，每个桶对应一个 key，桶按 UTC时间对齐
+type TimeSeries struct {
+	name        string
+	resolutions []Resolution
+}
+
+// NewTimeSeries 创建时间序列计数器，不传 resolutions时使用分钟、小时、天三种粒度
+// 粒度必须为整秒且不小于1秒
+func NewTimeSeries(name string, resolutions ...Resolution) (*TimeSeries, error) {
+	if len(resolutions) == 0 {
+		resolutions = []Resolution{ResolutionMinute, ResolutionHour, ResolutionDay}
+	}
+	for _, r := range resolutions {
+		if r.Step < time.Second || r.Step%time.Second != 0 {
+			return nil, fmt.Errorf("invalid time series step %s, want whole seconds", r.Step)
+		}
+	}
+	return &TimeSeries{name: name, resolutions: resolutions}, nil
+}
+
+// Incr 在 t所在的各粒度桶上加 n，并按保留时长设置过期时间
+func (ts *TimeSeries) Incr(ctx context.Context, t time.Time, n int64) bool {
+	pipe := client.Pipeline()
+	for _, r := range ts.resolutions {
+		bucket := ts.bucket(r.Step, t)
+		key := ts.key(r.Step, bucket)
+		pipe.IncrBy(ctx, key, n)
+		pipe.ExpireAt(ctx, key, time.Unix(bucket, 0).Add(r.Step+r.Retention))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.CtxWarn(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// Range 返回 [from, to] 区间内粒度为 step的连续序列，没有数据的桶补0
+// step未配置或 redis出错时返回 false，避免与真实的0值混淆
+func (ts *TimeSeries) Range(ctx context.Context, step time.Duration, from, to time.Time) (bool, Series) {
+	if !slices.ContainsFunc(ts.resolutions, func(r Resolution) bool { return r.Step == step }) {
+		logs.CtxWarn(ctx, "time series %s has no resolution of step %s", ts.name, step)
+		return false, nil
+	}
+	start, end := ts.bucket(step, from), ts.bucket(step, to)
+	if end < start {
+		return true, nil
+	}
+	sec := int64(step / time.Second)
+	series := make(Series, 0, (end-start)/sec+1)
+	keys := make([]string, 0, cap(series))
+	for b := start; b <= end; b += sec {
+		series = append(series, Point{Time: time.Unix(b, 0)})
+		keys = append(keys, ts.key(step, b))
+	}
+	// 分批 MGET，避免单次命令过大
+	for i := 0; i < len(keys); i += 500 {
+		j := min(i+500, len(keys))
+		vals, err := client.MGet(ctx, keys[i:j]...).Result()
+		if err != nil {
+			logs.CtxWarn(ctx, err.Error())
+			return false, nil
+		}
+		for k, v := range vals {
+			if s, ok := v.(string); ok {
+				series[i+k].Value, _ = strconv.ParseInt(s, 10, 64)
+			}
+		}
+	}
+	return true, series
+}
+
+// Sum 返回序列的总和
+func (s Series) Sum() int64 {
+	var sum int64
+	for _, p := range s {
+		sum += p.Value
+	}
+	return sum
+}
+
+// Min 返回值最小的桶
+func (s Series) Min() Point {
+	var m Point
+	for i, p := range s {
+		if i == 0 || p.Value < m.Value {
+			m = p
+		}
+	}
+	return m
+}
+
+// Max 返回值最大的桶
+func (s Series) Max() Point {
+	var m Point
+	for i, p := range s {
+		if i == 0 || p.Value > m.Value {
+			m = p
+		}
+	}
+	return m
+}
+
+// Avg 返回每个桶的平均值
+func (s Series) Avg() float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return float64(s.Sum()) / float64(len(s))
+}
+
+// bucket 返回 t所在桶的起始 unix秒
+func (ts *TimeSeries) bucket(step time.Duration, t time.Time) int64 {
+	sec := int64(step / time.Second)
+	return t.Unix() / sec * sec
+}
+
+func (ts *TimeSeries) key(step time.Duration, bucket int64) string {
+	return fmt.Sprintf("%s:%d:%d", ts.name, int64(step/time.Second), bucket)
+}
@@ -0,0 +1,56 @@
+package rd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeSeries(t *testing.T) {
+	server := startRedis(t)
+	ctx := context.Background()
+	ts, err := NewTimeSeries("ts:orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	ts.Incr(ctx, base, 1)
+	ts.Incr(ctx, base.Add(30*time.Second), 2)
+	ts.Incr(ctx, base.Add(3*time.Minute), 5)
+
+	ok, series := ts.Range(ctx, time.Minute, base, base.Add(4*time.Minute))
+	if !ok {
+		t.Fatal("range should succeed")
+	}
+	want := []int64{3, 0, 0, 5, 0}
+	if len(series) != len(want) {
+		t.Fatalf("len = %d, want %d", len(series), len(want))
+	}
+	for i, p := range series {
+		if p.Value != want[i] {
+			t.Fatalf("series[%d] = %d, want %d", i, p.Value, want[i])
+		}
+	}
+	if series.Sum() != 8 || series.Max().Value != 5 || series.Min().Value != 0 {
+		t.Fatalf("sum/max/min = %d/%d/%d", series.Sum(), series.Max().Value, series.Min().Value)
+	}
+	if _, hours := ts.Range(ctx, time.Hour, base, base); hours.Sum() != 8 {
+		t.Fatalf("hour rollup = %d, want 8", hours.Sum())
+	}
+	if ok, series := ts.Range(ctx, 5*time.Minute, base, base.Add(time.Hour)); ok || series != nil {
+		t.Fatalf("unconfigured step = %v %v, want false", ok, series)
+	}
+
+	server.Close()
+	if ok, series := ts.Range(ctx, time.Minute, base, base.Add(time.Minute)); ok || series != nil {
+		t.Fatalf("range without redis = %v %v, want false instead of zero counts", ok, series)
+	}
+}
+
+func TestTimeSeriesInvalidStep(t *testing.T) {
+	for _, step := range []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		if _, err := NewTimeSeries("ts:bad", Resolution{Step: step, Retention: time.Hour}); err == nil {
+			t.Fatalf("step %s should be rejected", step)
+		}
+	}
+}
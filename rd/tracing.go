@@ -0,0 +1,153 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*------------------------------------ 链路追踪 操作 ------------------------------------*/
+
+// statementLimit db.statement属性的最大长度
+const statementLimit = 512
+
+// TracingOptions 链路追踪配置
+type TracingOptions struct {
+	// 为 nil时使用 otel全局 provider
+	Provider trace.TracerProvider
+	// 为 true时 db.statement记录命令的全部参数，否则只记录命令名和 key
+	// 参数中可能包含缓存内容、会话等敏感数据，只应在排查问题时开启
+	WithArgs bool
+}
+
+// TracingHook 为每个 redis命令生成 OpenTelemetry span，pipeline整体生成一个 span
+type TracingHook struct {
+	tracer   trace.Tracer
+	attrs    []attribute.KeyValue
+	withArgs bool
+}
+
+// EnableTracing 为全局 redis客户端及分片客户端开启链路追踪，需在初始化客户端之后调用，span挂在 ctx中的 span下
+// 在 EnableReplicas之前调用时，发往从节点的命令由主客户端的 span覆盖；之后调用时同时为从节点客户端安装，span记录从节点地址
+func EnableTracing(opts TracingOptions) *TracingHook {
+	provider := opts.Provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	h := &TracingHook{
+		withArgs: opts.WithArgs,
+		tracer:   provider.Tracer("github.com/oho-panda/utils/v2/rd"),
+	}
+	primary := h.forClient(client.Options())
+	client.AddHook(primary)
+	if ring != nil {
+		ring.AddHook(primary)
+	}
+	// 读写分离的 hook先于追踪执行，路由到从节点的命令不会经过主客户端上的追踪
+	for _, r := range globalReplicas() {
+		for _, rp := range r.replicas {
+			rp.client.AddHook(h.forClient(rp.client.Options()))
+		}
+	}
+	return primary
+}
+
+// forClient 返回记录指定客户端地址的 hook
+func (h *TracingHook) forClient(opts *redis.Options) *TracingHook {
+	return &TracingHook{
+		tracer:   h.tracer,
+		withArgs: h.withArgs,
+		attrs: []attribute.KeyValue{
+			attribute.String("db.system", "redis"),
+			attribute.String("server.address", opts.Addr),
+			attribute.Int("db.redis.database_index", opts.DB),
+		},
+	}
+}
+
+func (h *TracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *TracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := h.tracer.Start(ctx, "redis."+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...),
+			trace.WithAttributes(
+				attribute.String("db.operation.name", cmd.FullName()),
+				attribute.String("db.statement", h.statement(cmd)),
+			),
+		)
+		defer span.End()
+		err := next(ctx, cmd)
+		recordSpanError(span, err)
+		return err
+	}
+}
+
+func (h *TracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		statements := make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			statements = append(statements, h.statement(cmd))
+		}
+		ctx, span := h.tracer.Start(ctx, "redis.pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...),
+			trace.WithAttributes(
+				attribute.Int("db.redis.num_cmd", len(cmds)),
+				attribute.String("db.statement", truncate(strings.Join(statements, "\n"), statementLimit)),
+			),
+		)
+		defer span.End()
+		err := next(ctx, cmds)
+		recordSpanError(span, err)
+		return err
+	}
+}
+
+// statement 返回命令名和 key，开启 WithArgs时返回全部参数
+func (h *TracingHook) statement(cmd redis.Cmder) string {
+	if h.withArgs {
+		return cmdStatement(cmd)
+	}
+	if key := cmdFirstKey(cmd); key != "" {
+		return truncate(cmd.FullName()+" "+key, statementLimit)
+	}
+	return cmd.FullName()
+}
+
+// cmdStatement 返回命令及参数，超过 statementLimit时截断
+func cmdStatement(cmd redis.Cmder) string {
+	var b strings.Builder
+	for i, arg := range cmd.Args() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprint(&b, arg)
+		if b.Len() > statementLimit {
+			break
+		}
+	}
+	return truncate(b.String(), statementLimit)
+}
+
+// recordSpanError 记录命令错误，key不存在不视为错误
+func recordSpanError(span trace.Span, err error) {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,95 @@
+package rd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	startRedis(t)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	EnableTracing(TracingOptions{Provider: provider})
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "handler")
+	Set(ctx, "k", "v")
+	Get(ctx, "missing")
+	client.Incr(ctx, "k")
+	pipe := client.Pipeline()
+	pipe.Get(ctx, "k")
+	pipe.Del(ctx, "k")
+	_, _ = pipe.Exec(ctx)
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 5 {
+		t.Fatalf("spans = %d, want 5", len(spans))
+	}
+	attrs := func(i int) map[attribute.Key]attribute.Value {
+		m := map[attribute.Key]attribute.Value{}
+		for _, kv := range spans[i].Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	if spans[0].Name() != "redis.set" || attrs(0)["db.statement"].AsString() != "set k" || attrs(0)["db.system"].AsString() != "redis" {
+		t.Fatalf("set span = %s %v", spans[0].Name(), attrs(0))
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("command span should be a child of the context span")
+	}
+	if spans[1].Status().Code == codes.Error {
+		t.Fatal("redis.Nil should not mark the span as error")
+	}
+	if spans[2].Status().Code != codes.Error {
+		t.Fatal("incr on a non-integer should mark the span as error")
+	}
+	if spans[3].Name() != "redis.pipeline" || attrs(3)["db.redis.num_cmd"].AsInt64() != 2 {
+		t.Fatalf("pipeline span = %s %v", spans[3].Name(), attrs(3))
+	}
+}
+
+func TestTracingWithArgs(t *testing.T) {
+	startRedis(t)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	EnableTracing(TracingOptions{Provider: provider, WithArgs: true})
+
+	Set(context.Background(), "k", "secret")
+	for _, kv := range recorder.Ended()[0].Attributes() {
+		if kv.Key == "db.statement" && kv.Value.AsString() != "set k secret" {
+			t.Fatalf("statement = %q, want full args", kv.Value.AsString())
+		}
+	}
+}
+
+func TestTracingReplicas(t *testing.T) {
+	startRedis(t)
+	replica := miniredis.RunT(t)
+	router := EnableReplicas([]string{replica.Addr()}, "", time.Second, ReplicaRoundRobin)
+	defer router.Close()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	EnableTracing(TracingOptions{Provider: provider})
+
+	_ = replica.Set("k", "v")
+	if _, v := Get(context.Background(), "k"); v != "v" {
+		t.Fatalf("get = %q, want the replica value", v)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "redis.get" {
+		t.Fatalf("spans = %v, want one span for the replica read", spans)
+	}
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == "server.address" && kv.Value.AsString() != replica.Addr() {
+			t.Fatalf("server.address = %s, want the replica %s", kv.Value.AsString(), replica.Addr())
+		}
+	}
+}